@@ -2,20 +2,47 @@ package main
 
 import (
 	"os"
+	"strconv"
+	"time"
 	log "github.com/sirupsen/logrus"
+	"github.com/Project-Wartemis/pw-backend/internal/auth"
 	"github.com/Project-Wartemis/pw-backend/internal/base"
 	"github.com/Project-Wartemis/pw-backend/internal/master"
 	"github.com/Project-Wartemis/pw-backend/internal/http"
+	"github.com/Project-Wartemis/pw-backend/internal/storage"
+	"github.com/Project-Wartemis/pw-backend/internal/util"
+	"github.com/Project-Wartemis/pw-backend/internal/validation"
 )
 
 func main() {
-	LOG_LEVEL, PORT := getSettings()
+	LOG_LEVEL, PORT, STORAGE_PATH := getSettings()
 
 	log.SetLevel(LOG_LEVEL)
 
 	log.Info("Execute main")
 
-	lobby := base.NewLobby()
+	store, err := storage.NewBoltStorage(STORAGE_PATH)
+	if err != nil {
+		log.Panicf("Could not open storage [%s] : [%s]", STORAGE_PATH, err)
+	}
+	defer store.Close()
+
+	lobby := base.NewLobby(store, getAuthenticator())
+	err = lobby.LoadGames()
+	if err != nil {
+		log.Panicf("Could not load games from storage : [%s]", err)
+	}
+	err = lobby.LoadTokens()
+	if err != nil {
+		log.Panicf("Could not load tokens from storage : [%s]", err)
+	}
+	err = lobby.LoadRatings()
+	if err != nil {
+		log.Panicf("Could not load ratings from storage : [%s]", err)
+	}
+
+	lobby.GetMatchmaker().Start()
+	lobby.GetReaper().Start()
 
 	lobbyHttpInterface := http.NewLobbyHttpInterface(lobby)
 
@@ -25,15 +52,80 @@ func main() {
 	router.Start(PORT)
 }
 
-func getSettings() (LOG_LEVEL log.Level, PORT int) {
+func getSettings() (LOG_LEVEL log.Level, PORT int, STORAGE_PATH string) {
 	switch os.Getenv("WARTEMIS_ENV") {
 		case "BUILD":
 			LOG_LEVEL = log.InfoLevel
 			PORT = 80
+			STORAGE_PATH = "/data/wartemis.db"
 		default:
 			// LOG_LEVEL = log.DebugLevel
 			LOG_LEVEL = log.InfoLevel
 			PORT = 8080
+			STORAGE_PATH = "wartemis.db"
+	}
+	if path := os.Getenv("WARTEMIS_STORAGE"); path != "" {
+		STORAGE_PATH = path
+	}
+	if path := os.Getenv("WARTEMIS_SCHEMAS"); path != "" {
+		validation.SCHEMA_DIRECTORY = path
+	}
+	getDuration("WARTEMIS_ENGINE_TIMEOUT", &base.ENGINE_RECONNECT_TIMEOUT)
+	getDuration("WARTEMIS_CLIENT_TTL", &base.CLIENT_TTL)
+	getDuration("WARTEMIS_GAME_RETENTION", &base.GAME_RETENTION)
+	getDuration("WARTEMIS_REAPER_INTERVAL", &base.REAPER_INTERVAL)
+	if size := os.Getenv("WARTEMIS_OUTBOX_SIZE"); size != "" {
+		value, err := strconv.Atoi(size)
+		if err != nil || value < 1 {
+			log.Panicf("Invalid WARTEMIS_OUTBOX_SIZE [%s]", size)
+		}
+		base.OUTBOX_SIZE = value
+	}
+	if policy := os.Getenv("WARTEMIS_OUTBOX_POLICY"); policy != "" {
+		if !util.Includes(base.OUTBOX_POLICIES, policy) {
+			log.Panicf("Invalid WARTEMIS_OUTBOX_POLICY [%s], expected one of %v", policy, base.OUTBOX_POLICIES)
+		}
+		base.OUTBOX_POLICY = policy
 	}
 	return
 }
+
+// overrides the duration with the number of milliseconds in the env var, if it is set
+func getDuration(name string, duration *time.Duration) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+	milliseconds, err := strconv.Atoi(value)
+	if err != nil || milliseconds < 0 {
+		log.Panicf("Invalid %s [%s]", name, value)
+	}
+	*duration = time.Duration(milliseconds) * time.Millisecond
+}
+
+// tokens can be configured as "type:name:token" entries, comma separated in an env var or one per line in a file
+func getAuthenticator() *auth.Authenticator {
+	authenticator := auth.NewAuthenticator(os.Getenv("WARTEMIS_ADMIN_TOKEN"))
+
+	tokens, err := auth.ParseTokens(os.Getenv("WARTEMIS_TOKENS"))
+	if err != nil {
+		log.Panicf("Could not parse WARTEMIS_TOKENS : [%s]", err)
+	}
+	if path := os.Getenv("WARTEMIS_TOKENS_FILE"); path != "" {
+		fileTokens, err := auth.ParseTokensFile(path)
+		if err != nil {
+			log.Panicf("Could not load tokens : [%s]", err)
+		}
+		tokens = append(tokens, fileTokens...)
+	}
+	for _,token := range tokens {
+		authenticator.AddToken(token)
+	}
+
+	if authenticator.IsEnabled() {
+		log.Infof("Authentication enabled with [%d] configured tokens", len(tokens))
+	} else {
+		log.Warn("Authentication disabled, anyone can register as a bot or an engine")
+	}
+	return authenticator
+}
@@ -10,4 +10,5 @@ require (
 	github.com/qri-io/jsonschema v0.1.1
 	github.com/sasha-s/go-deadlock v0.2.0
 	github.com/sirupsen/logrus v1.5.0
+	go.etcd.io/bbolt v1.3.5
 )
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"github.com/google/uuid"
+	sync "github.com/sasha-s/go-deadlock"
+)
+
+const (
+	ANY_NAME = "*" // a token with this name can be used to register with any name
+)
+
+// allows a client to register with the given type and name
+type Token struct {
+	Token string      `json:"token"`
+	ClientType string `json:"clientType"`
+	Name string       `json:"name"`
+}
+
+// authentication is only enforced when an admin token or at least one token is configured
+type Authenticator struct {
+	sync.RWMutex
+	adminToken string
+	tokens map[string]*Token
+}
+
+func NewAuthenticator(adminToken string) *Authenticator {
+	return &Authenticator {
+		adminToken: adminToken,
+		tokens: map[string]*Token{},
+	}
+}
+
+// parses tokens in the format "type:name:token", separated by commas or newlines
+// empty lines and lines starting with # are ignored
+func ParseTokens(raw string) ([]*Token, error) {
+	result := []*Token{}
+	lines := strings.FieldsFunc(raw, func(c rune) bool {
+		return c == ',' || c == '\n'
+	})
+	for _,line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, errors.New(fmt.Sprintf("Invalid token [%s], expected [type:name:token]", line))
+		}
+		result = append(result, &Token {
+			ClientType: parts[0],
+			Name: parts[1],
+			Token: parts[2],
+		})
+	}
+	return result, nil
+}
+
+func ParseTokensFile(path string) ([]*Token, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not read token file [%s] : [%s]", path, err))
+	}
+	return ParseTokens(string(raw))
+}
+
+func (this *Authenticator) AddToken(token *Token) {
+	this.Lock()
+	defer this.Unlock()
+	this.tokens[token.Token] = token
+}
+
+// creates a new random token
+func (this *Authenticator) Issue(clientType string, name string) *Token {
+	token := &Token {
+		Token: uuid.New().String(),
+		ClientType: clientType,
+		Name: name,
+	}
+	this.AddToken(token)
+	return token
+}
+
+func (this *Authenticator) IsEnabled() bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.adminToken != "" || len(this.tokens) > 0
+}
+
+func (this *Authenticator) IsAdmin(token string) bool {
+	this.RLock()
+	defer this.RUnlock()
+	if this.adminToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(this.adminToken)) == 1
+}
+
+// the admin token can be used to register as anything
+func (this *Authenticator) Authenticate(token string, clientType string, name string) error {
+	if !this.IsEnabled() || this.IsAdmin(token) {
+		return nil
+	}
+	if token == "" {
+		return errors.New(fmt.Sprintf("A token is required to register as a [%s]", clientType))
+	}
+
+	this.RLock()
+	defer this.RUnlock()
+	found := this.tokens[token]
+	if found == nil {
+		return errors.New("Invalid token")
+	}
+	if found.ClientType != clientType {
+		return errors.New(fmt.Sprintf("Token is not valid for a [%s]", clientType))
+	}
+	if found.Name != ANY_NAME && found.Name != name {
+		return errors.New(fmt.Sprintf("Token is not valid for name [%s]", name))
+	}
+	return nil
+}
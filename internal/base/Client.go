@@ -1,12 +1,18 @@
 package base
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
+	"github.com/google/uuid"
 	sync "github.com/sasha-s/go-deadlock"
 	log "github.com/sirupsen/logrus"
 	msg "github.com/Project-Wartemis/pw-backend/internal/message"
+	"github.com/Project-Wartemis/pw-backend/internal/storage"
 	"github.com/Project-Wartemis/pw-backend/internal/util"
+	"github.com/Project-Wartemis/pw-backend/internal/validation"
 )
 
 const (
@@ -25,9 +31,17 @@ type Client struct {
 	Id int      `json:"id"`
 	Type string `json:"type"`
 	Name string `json:"name"`
-	Game string `json:"game"` // used for bots to specify which game they want to play
+	Game string `json:"game"` // used for bots to specify which game they want to play, and for engines which game they run
+	AcceptInvites bool `json:"acceptInvites"` // used for bots to accept invites to games of any engine
 	lobby *Lobby
 	connection *Connection
+	actionSchema *validation.Schema // used by engines to validate the actions of bots
+	stateSchema *validation.Schema  // used by engines to validate their own states
+	batchActions bool               // used by engines to receive all actions of a turn at once
+	playerCount int                 // used by engines to take part in matchmaking
+	admin bool                      // registered with the admin token
+	resumeToken string              // secret that lets a new connection take over this client
+	disconnectedAt time.Time        // disconnected clients are removed from the lobby after a while
 }
 
 func NewClient(lobby *Lobby, connection *Connection) *Client {
@@ -41,26 +55,44 @@ func NewClient(lobby *Lobby, connection *Connection) *Client {
 	return client
 }
 
+// a client that was loaded from storage, it is never connected
+func newRestoredClient(lobby *Lobby, record *storage.ClientRecord) *Client {
+	return &Client {
+		Id: record.Id,
+		Type: record.Type,
+		Name: record.Name,
+		Game: record.Game,
+		lobby: lobby,
+		connection: nil,
+	}
+}
+
+func (this *Client) toRecord() *storage.ClientRecord {
+	this.RLock()
+	defer this.RUnlock()
+	return &storage.ClientRecord {
+		Id: this.Id,
+		Type: this.Type,
+		Name: this.Name,
+		Game: this.Game,
+	}
+}
+
 
 
 // communication related stuff
 
+// does not block, the message is queued on the connection
 func (this *Client) SendMessage(message interface{}) {
-	go func() {
-		log.Debugf("Sending message to [%s]: [%s]", this.GetName(), message)
+	log.Debugf("Sending message to [%s]: [%s]", this.GetName(), message)
 
-		connection := this.GetConnection()
-		if connection == nil {
-			log.Warnf("Cannot send a message to [%s] because not connected", this.GetName())
-			return
-		}
+	connection := this.GetConnection()
+	if connection == nil {
+		log.Warnf("Cannot send a message to [%s] because not connected", this.GetName())
+		return
+	}
 
-		err := connection.SendMessage(message)
-		if err != nil {
-			log.Errorf("Unexpected error while sending message to [%s] : [%s]", this.GetName(), err)
-			return
-		}
-	}()
+	connection.SendMessage(message)
 }
 
 func (this *Client) SendError(message string) {
@@ -68,6 +100,36 @@ func (this *Client) SendError(message string) {
 	this.SendMessage(msg.NewErrorMessage(message))
 }
 
+func (this *Client) SendValidationErrors(message string, mistakes []string) {
+	log.Infof("Sending validation errors to [%s]: [%s] : [%s]", this.GetName(), message, mistakes)
+	this.SendMessage(msg.NewValidationErrorMessage(message, mistakes))
+}
+
+// the game an engine runs, which is its name unless it declared one
+func (this *Client) GetPlayedGame() string {
+	game := this.GetGame()
+	if game == "" && this.GetType() == TYPE_ENGINE {
+		return this.GetName()
+	}
+	return game
+}
+
+// bots can play the game they declared, bots without a game can play anything if they accept invites
+func (this *Client) CanPlay(engine *Client) error {
+	game := this.GetGame()
+	if game == "" {
+		if this.GetAcceptInvites() {
+			return nil
+		}
+		return errors.New(fmt.Sprintf("Bot [%s] did not declare a game and does not accept invites", this.GetName()))
+	}
+	played := engine.GetPlayedGame()
+	if game != played {
+		return errors.New(fmt.Sprintf("Bot [%s] plays [%s], but engine [%s] runs [%s]", this.GetName(), game, engine.GetName(), played))
+	}
+	return nil
+}
+
 func (this *Client) HandleDisconnect() {
 	this.SetConnection(nil)
 	this.getLobby().HandleDisconnect(this)
@@ -84,24 +146,52 @@ func (this *Client) HandleMessage(raw []byte) {
 		this.SendError(fmt.Sprintf("Could not parse message: [%s]", raw))
 		return
 	}
+
+	mistakes, err := this.getLobby().GetValidator().Validate(message.Type, raw)
+	if err != nil {
+		this.SendError(fmt.Sprintf("Could not parse message: [%s]", raw))
+		return
+	}
+	if len(mistakes) > 0 {
+		this.SendValidationErrors(fmt.Sprintf("Invalid message of type [%s]", message.Type), mistakes)
+		return
+	}
+
+	if message.Type != "register" && !this.IsRegistered() {
+		this.SendError(fmt.Sprintf("You need to register before sending a [%s] message", message.Type))
+		return
+	}
+
 	handler := this.handleDefault
 	switch message.Type {
 		case "action":
 			handler = this.handleActionMessage
 		case "game":
 			handler = this.handleGameMessage
+		case "history":
+			handler = this.handleHistoryMessage
 		case "invite":
 			handler = this.handleInviteMessage
 		case "join":
 			handler = this.handleJoinMessage
+		case "leaderboard":
+			handler = this.handleLeaderboardMessage
 		case "leave":
 			handler = this.handleLeaveMessage
+		case "pause":
+			handler = this.handlePauseMessage
+		case "queue":
+			handler = this.handleQueueMessage
 		case "register":
 			handler = this.handleRegisterMessage
+		case "resume":
+			handler = this.handleResumeMessage
 		case "start":
 			handler = this.handleStartMessage
 		case "state":
 			handler = this.handleStateMessage
+		case "step":
+			handler = this.handleStepMessage
 		case "stop":
 			handler = this.handleStopMessage
 	}
@@ -149,12 +239,54 @@ func (this *Client) handleGameMessage(raw []byte) {
 		this.SendError(fmt.Sprintf("Could not find engine with id [%d]", message.Engine))
 		return
 	}
+	if engine.GetType() != TYPE_ENGINE {
+		this.SendError(fmt.Sprintf("Client [%d] is not an engine", message.Engine))
+		return
+	}
 
 	game := NewGame(message.Name, engine)
+	game.SetCreator(this)
+	game.SetMoveTimeout(message.MoveTimeout)
+	if message.Autoplay != nil {
+		game.SetAutoplay(*message.Autoplay)
+	}
+	game.SetReconnectTimeout(message.ReconnectTimeout)
+	if message.Forfeit != "" {
+		err = game.SetForfeitPolicy(message.Forfeit)
+		if err != nil {
+			this.SendError(fmt.Sprintf("Could not create game: [%s]", err))
+			return
+		}
+	}
 	this.getLobby().AddGame(game)
 	this.SendMessage(msg.NewCreatedMessage(game.GetId()))
 }
 
+func (this *Client) handleHistoryMessage(raw []byte) {
+	if this.GetType() != TYPE_VIEWER {
+		this.SendError(fmt.Sprintf("You are not allowed to send a history message"))
+		return
+	}
+
+	message, err := msg.ParseHistoryRequestMessage(raw)
+	if err != nil {
+		this.SendError(fmt.Sprintf("Could not parse message: [%s]", raw))
+		return
+	}
+
+	game := this.getLobby().GetGameById(message.Game)
+	if game == nil {
+		this.SendError(fmt.Sprintf("Game [%d] not found", message.Game))
+		return
+	}
+
+	to := message.From // a single turn, still in a history message so it is not mistaken for the current state
+	if message.To != nil {
+		to = *message.To
+	}
+	game.GetHistory().SendTurnsToViewer(this, message.From, to)
+}
+
 func (this *Client) handleInviteMessage(raw []byte) {
 	message, err := msg.ParseInviteMessage(raw)
 	if err != nil {
@@ -168,6 +300,11 @@ func (this *Client) handleInviteMessage(raw []byte) {
 		return
 	}
 
+	if !game.CanControl(this) {
+		this.SendError(fmt.Sprintf("You are not allowed to invite bots to game [%d]", message.Game))
+		return
+	}
+
 	bot := this.getLobby().GetClientById(message.Bot)
 	if bot == nil {
 		this.SendError(fmt.Sprintf("Bot [%d] not found", message.Bot))
@@ -178,12 +315,22 @@ func (this *Client) handleInviteMessage(raw []byte) {
 		return
 	}
 
-	game.AddPlayer(bot)
+	err = game.AddPlayer(bot)
+	if err != nil {
+		this.SendError(fmt.Sprintf("Could not invite bot [%d]: [%s]", message.Bot, err))
+		return
+	}
+
 	this.getLobby().TriggerUpdated()
 }
 
 func (this *Client) handleJoinMessage(raw []byte) {
-	message, err := msg.ParseInviteMessage(raw)
+	if this.GetType() != TYPE_VIEWER {
+		this.SendError(fmt.Sprintf("You are not allowed to send a join message"))
+		return
+	}
+
+	message, err := msg.ParseJoinMessage(raw)
 	if err != nil {
 		this.SendError(fmt.Sprintf("Could not parse message: [%s]", raw))
 		return
@@ -196,10 +343,26 @@ func (this *Client) handleJoinMessage(raw []byte) {
 	}
 
 	game.AddClient(this)
-	game.GetHistory().SendAllToViewer(this)
+	game.SetViewerFormat(this, message.Format)
+	if message.History == nil || *message.History {
+		game.GetHistory().SendAllToViewer(this)
+	} else {
+		game.GetHistory().SendLatestToViewer(this)
+	}
 	this.getLobby().TriggerUpdated()
 }
 
+func (this *Client) handleLeaderboardMessage(raw []byte) {
+	message, err := msg.ParseLeaderboardRequestMessage(raw)
+	if err != nil {
+		this.SendError(fmt.Sprintf("Could not parse message: [%s]", raw))
+		return
+	}
+
+	ratings := this.getLobby().GetLeaderboard().GetRatings(message.Engine)
+	this.SendMessage(msg.NewLeaderboardMessage(message.Engine, ratings))
+}
+
 func (this *Client) handleLeaveMessage(raw []byte) {
 	message, err := msg.ParseLeaveMessage(raw)
 	if err != nil {
@@ -217,6 +380,70 @@ func (this *Client) handleLeaveMessage(raw []byte) {
 	this.getLobby().TriggerUpdated()
 }
 
+func (this *Client) handlePauseMessage(raw []byte) {
+	if this.GetType() != TYPE_VIEWER {
+		this.SendError(fmt.Sprintf("You are not allowed to send a pause message"))
+		return
+	}
+
+	message, err := msg.ParsePauseMessage(raw)
+	if err != nil {
+		this.SendError(fmt.Sprintf("Could not parse message: [%s]", raw))
+		return
+	}
+
+	game := this.getLobby().GetGameById(message.Game)
+	if game == nil {
+		this.SendError(fmt.Sprintf("Game [%d] not found", message.Game))
+		return
+	}
+
+	if !game.CanControl(this) {
+		this.SendError(fmt.Sprintf("You are not allowed to pause game [%d]", message.Game))
+		return
+	}
+
+	err = game.Pause()
+	if err != nil {
+		this.SendError(fmt.Sprintf("Could not pause game [%d]: [%s]", message.Game, err))
+		return
+	}
+
+	this.getLobby().TriggerUpdated()
+}
+
+func (this *Client) handleQueueMessage(raw []byte) {
+	if this.GetType() != TYPE_BOT {
+		this.SendError(fmt.Sprintf("You are not allowed to send a queue message"))
+		return
+	}
+
+	message, err := msg.ParseQueueMessage(raw)
+	if err != nil {
+		this.SendError(fmt.Sprintf("Could not parse message: [%s]", raw))
+		return
+	}
+
+	game := this.GetGame()
+	if game == "" {
+		this.SendError("Could not queue: [You need to register with a game]")
+		return
+	}
+	if message.Game != "" && message.Game != game {
+		this.SendError(fmt.Sprintf("Could not queue: [You registered for game [%s], not for [%s]]", game, message.Game))
+		return
+	}
+
+	matchmaker := this.getLobby().GetMatchmaker()
+	err = matchmaker.Enqueue(this, game)
+	if err != nil {
+		this.SendError(fmt.Sprintf("Could not queue: [%s]", err))
+		return
+	}
+	this.SendMessage(msg.NewQueuedMessage(game))
+	matchmaker.Trigger()
+}
+
 func (this *Client) handleRegisterMessage(raw []byte) {
 	message, err := msg.ParseRegisterMessage(raw)
 	if err != nil {
@@ -229,19 +456,112 @@ func (this *Client) handleRegisterMessage(raw []byte) {
 		return
 	}
 
+	if message.ClientType != TYPE_VIEWER {
+		err = this.getLobby().GetAuthenticator().Authenticate(message.Token, message.ClientType, message.Name)
+		if err != nil {
+			log.Warnf("Client [%s] failed to authenticate as a [%s] : [%s]", message.Name, message.ClientType, err)
+			this.SendError(fmt.Sprintf("Could not register: [%s]", err))
+			return
+		}
+	}
+
+	actionSchema, stateSchema, err := parseEngineSchemas(message)
+	if err != nil {
+		this.SendError(fmt.Sprintf("Could not register: [%s]", err))
+		return
+	}
+
+	resumed, err := this.getLobby().FindResumedClient(message.ResumeToken, message.ClientType, message.Name)
+	if err != nil {
+		log.Warnf("Client [%s] failed to register as a [%s] : [%s]", message.Name, message.ClientType, err)
+		this.SendError(fmt.Sprintf("Could not register: [%s]", err))
+		return
+	}
+
 	this.setType(message.ClientType)
 	this.setName(message.Name)
 	this.setGame(message.Game)
+	this.setActionSchema(actionSchema)
+	this.setStateSchema(stateSchema)
+	this.setBatchActions(message.ClientType == TYPE_ENGINE && message.BatchActions)
+	this.setAcceptInvites(message.ClientType == TYPE_BOT && message.AcceptInvites)
+	this.setAdmin(this.getLobby().GetAuthenticator().IsAdmin(message.Token))
+	if message.ClientType == TYPE_ENGINE {
+		this.setPlayerCount(message.Players)
+	}
 
 	log.Infof("client [%s] registered as a [%s]", this.GetName(), this.GetType())
 
-	duplicate := this.getLobby().FindDuplicateUnconnectedClient(this)
-	if duplicate != nil {
-		this.getLobby().HandleReconnect(this, duplicate)
-		this = duplicate
+	if resumed != nil {
+		this.getLobby().HandleReconnect(this, resumed)
+		this = resumed
+	} else if this.GetType() == TYPE_VIEWER && message.ResumeToken != "" {
+		this.setResumeToken(message.ResumeToken) // checked by FindResumedClient, it belongs to the games this viewer created
+	} else {
+		this.setResumeToken(uuid.New().String())
+	}
+
+	this.SendMessage(msg.NewRegisteredMessage(this.GetId(), this.getResumeToken()))
+	this.getLobby().TriggerUpdated()
+	if this.GetType() == TYPE_ENGINE {
+		this.getLobby().GetMatchmaker().Trigger()
+	}
+}
+
+func parseEngineSchemas(message *msg.RegisterMessage) (actionSchema *validation.Schema, stateSchema *validation.Schema, err error) {
+	if len(message.ActionSchema) == 0 && len(message.StateSchema) == 0 {
+		return
+	}
+	if message.ClientType != TYPE_ENGINE {
+		err = errors.New("Only engines can provide an action or state schema")
+		return
+	}
+	if len(message.ActionSchema) > 0 {
+		actionSchema, err = validation.NewSchema(message.ActionSchema)
+		if err != nil {
+			err = errors.New(fmt.Sprintf("Invalid action schema [%s]", err))
+			return
+		}
+	}
+	if len(message.StateSchema) > 0 {
+		stateSchema, err = validation.NewSchema(message.StateSchema)
+		if err != nil {
+			err = errors.New(fmt.Sprintf("Invalid state schema [%s]", err))
+			return
+		}
+	}
+	return
+}
+
+func (this *Client) handleResumeMessage(raw []byte) {
+	if this.GetType() != TYPE_VIEWER {
+		this.SendError(fmt.Sprintf("You are not allowed to send a resume message"))
+		return
+	}
+
+	message, err := msg.ParseResumeMessage(raw)
+	if err != nil {
+		this.SendError(fmt.Sprintf("Could not parse message: [%s]", raw))
+		return
+	}
+
+	game := this.getLobby().GetGameById(message.Game)
+	if game == nil {
+		this.SendError(fmt.Sprintf("Game [%d] not found", message.Game))
+		return
+	}
+
+	if !game.CanControl(this) {
+		this.SendError(fmt.Sprintf("You are not allowed to resume game [%d]", message.Game))
+		return
+	}
+
+	err = game.Resume()
+	if err != nil {
+		this.SendError(fmt.Sprintf("Could not resume game [%d]: [%s]", message.Game, err))
+		return
 	}
 
-	this.SendMessage(msg.NewRegisteredMessage(this.GetId()))
 	this.getLobby().TriggerUpdated()
 }
 
@@ -258,6 +578,11 @@ func (this *Client) handleStartMessage(raw []byte) {
 		return
 	}
 
+	if !game.CanControl(this) {
+		this.SendError(fmt.Sprintf("You are not allowed to start game [%d]", message.Game))
+		return
+	}
+
 	err = game.Start()
 	if err != nil {
 		this.SendError(fmt.Sprintf("Could not start game [%d]: [%s]", message.Game, err))
@@ -284,10 +609,48 @@ func (this *Client) handleStateMessage(raw []byte) {
 		this.SendError(fmt.Sprintf("Game [%d] not found", message.Game))
 		return
 	}
+	if game.getEngine() != this {
+		this.SendError(fmt.Sprintf("You are not the engine of game [%d]", message.Game))
+		return
+	}
+	if game.GetReplay() {
+		this.SendError(fmt.Sprintf("Game [%d] is a replay and cannot receive states", message.Game))
+		return
+	}
 
 	game.HandleStateMessage(message)
 }
 
+func (this *Client) handleStepMessage(raw []byte) {
+	if this.GetType() != TYPE_VIEWER {
+		this.SendError(fmt.Sprintf("You are not allowed to send a step message"))
+		return
+	}
+
+	message, err := msg.ParseStepMessage(raw)
+	if err != nil {
+		this.SendError(fmt.Sprintf("Could not parse message: [%s]", raw))
+		return
+	}
+
+	game := this.getLobby().GetGameById(message.Game)
+	if game == nil {
+		this.SendError(fmt.Sprintf("Game [%d] not found", message.Game))
+		return
+	}
+
+	if !game.CanControl(this) {
+		this.SendError(fmt.Sprintf("You are not allowed to step game [%d]", message.Game))
+		return
+	}
+
+	err = game.Step()
+	if err != nil {
+		this.SendError(fmt.Sprintf("Could not step game [%d]: [%s]", message.Game, err))
+		return
+	}
+}
+
 func (this *Client) handleStopMessage(raw []byte) {
 	if this.GetType() != TYPE_ENGINE {
 		this.SendError(fmt.Sprintf("You are not allowed to send a stop message"))
@@ -306,7 +669,12 @@ func (this *Client) handleStopMessage(raw []byte) {
 		return
 	}
 
-	err = game.Stop()
+	if game.getEngine() != this {
+		this.SendError(fmt.Sprintf("You are not the engine of game [%d]", message.Game))
+		return
+	}
+
+	err = game.Stop(message.Result)
 	if err != nil {
 		this.SendError(fmt.Sprintf("Could not stop game [%d]: [%s]", message.Game, err))
 		return
@@ -361,6 +729,104 @@ func (this *Client) setGame(game string) {
 	this.Game = game
 }
 
+func (this *Client) GetActionSchema() *validation.Schema {
+	this.RLock()
+	defer this.RUnlock()
+	return this.actionSchema
+}
+
+func (this *Client) setActionSchema(schema *validation.Schema) {
+	this.Lock()
+	defer this.Unlock()
+	this.actionSchema = schema
+}
+
+func (this *Client) GetStateSchema() *validation.Schema {
+	this.RLock()
+	defer this.RUnlock()
+	return this.stateSchema
+}
+
+func (this *Client) setStateSchema(schema *validation.Schema) {
+	this.Lock()
+	defer this.Unlock()
+	this.stateSchema = schema
+}
+
+func (this *Client) GetBatchActions() bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.batchActions
+}
+
+func (this *Client) setBatchActions(batchActions bool) {
+	this.Lock()
+	defer this.Unlock()
+	this.batchActions = batchActions
+}
+
+func (this *Client) GetPlayerCount() int {
+	this.RLock()
+	defer this.RUnlock()
+	return this.playerCount
+}
+
+func (this *Client) setPlayerCount(playerCount int) {
+	this.Lock()
+	defer this.Unlock()
+	this.playerCount = playerCount
+}
+
+func (this *Client) GetAcceptInvites() bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.AcceptInvites
+}
+
+func (this *Client) setAcceptInvites(acceptInvites bool) {
+	this.Lock()
+	defer this.Unlock()
+	this.AcceptInvites = acceptInvites
+}
+
+func (this *Client) IsAdmin() bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.admin
+}
+
+func (this *Client) setAdmin(admin bool) {
+	this.Lock()
+	defer this.Unlock()
+	this.admin = admin
+}
+
+func (this *Client) getResumeToken() string {
+	this.RLock()
+	defer this.RUnlock()
+	return this.resumeToken
+}
+
+func (this *Client) setResumeToken(resumeToken string) {
+	this.Lock()
+	defer this.Unlock()
+	this.resumeToken = resumeToken
+}
+
+// clients without a resume token can never be resumed
+func (this *Client) MatchesResumeToken(resumeToken string) bool {
+	expected := this.getResumeToken()
+	if expected == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(resumeToken)) == 1
+}
+
+// a client that did not register yet has no type
+func (this *Client) IsRegistered() bool {
+	return this.GetType() != ""
+}
+
 func (this *Client) getLobby() *Lobby {
 	this.RLock()
 	defer this.RUnlock()
@@ -383,14 +849,30 @@ func (this *Client) SetConnection(connection *Connection) {
 
 	this.connection = connection
 	if connection != nil {
+		this.disconnectedAt = time.Time{}
 		go connection.StartPinging()
+	} else {
+		this.disconnectedAt = time.Now()
 	}
 }
 
+// zero while connected
+func (this *Client) GetDisconnectedAt() time.Time {
+	this.RLock()
+	defer this.RUnlock()
+	return this.disconnectedAt
+}
+
 func (this *Client) Transfer(client *Client) {
 	this.setType(client.GetType())
 	this.setName(client.GetName())
 	this.setGame(client.GetGame())
+	this.setActionSchema(client.GetActionSchema())
+	this.setStateSchema(client.GetStateSchema())
+	this.setBatchActions(client.GetBatchActions())
+	this.setPlayerCount(client.GetPlayerCount())
+	this.setAcceptInvites(client.GetAcceptInvites())
+	this.setAdmin(client.IsAdmin())
 	connection := client.GetConnection()
 	client.SetConnection(nil)
 	this.SetConnection(connection)
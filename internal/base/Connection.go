@@ -3,25 +3,70 @@ package base
 import (
 	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
 	"time"
 	sync "github.com/sasha-s/go-deadlock"
 	log "github.com/sirupsen/logrus"
 	"github.com/gorilla/websocket"
+	msg "github.com/Project-Wartemis/pw-backend/internal/message"
 )
 
+const (
+	OUTBOX_DROP_OLDEST = "drop-oldest" // the oldest queued message that is not a state is dropped
+	OUTBOX_DISCONNECT  = "disconnect"  // the connection is closed
+	OUTBOX_COALESCE    = "coalesce"    // a queued lobby update is replaced by the new one, otherwise the same as drop-oldest
+)
+
+var (
+	OUTBOX_POLICIES = []string{OUTBOX_DROP_OLDEST, OUTBOX_DISCONNECT, OUTBOX_COALESCE}
+	OUTBOX_SIZE = 1024 // the number of messages that can wait to be sent to a single connection
+	OUTBOX_POLICY = OUTBOX_COALESCE // what happens when a message is sent to a connection with a full outbox
+	OUTBOX_METRICS = expvar.NewMap("outbox") // published on /debug/vars
+	OUTBOX_MAX_DEPTH = new(expvar.Int) // the deepest a single outbox has been
+	CONNECTIONS = map[*Connection]bool{} // the open connections, for their metrics
+	CONNECTIONS_LOCK sync.Mutex // also guards OUTBOX_MAX_DEPTH
+)
+
+func init() {
+	OUTBOX_METRICS.Set("maxDepth", OUTBOX_MAX_DEPTH)
+	expvar.Publish("outboxes", expvar.Func(getOutboxMetrics))
+}
+
+// the metrics of a single outbox, as published on /debug/vars
+type OutboxMetrics struct {
+	Client string `json:"client"`
+	Depth int     `json:"depth"`
+	MaxDepth int  `json:"maxDepth"`
+	Dropped int   `json:"dropped"`
+}
+
 type Connection struct {
 	sync.RWMutex
 	sendLock sync.Mutex // we cannot send two messages concurrently
 	client *Client
 	connection *websocket.Conn
 	pinger *time.Ticker
+	outboxLock sync.Mutex
+	outbox []interface{} // messages waiting for the writer, oldest first
+	maxDepth int
+	dropped int
+	wakeup chan bool     // signals the writer that the outbox is no longer empty
+	done chan bool       // closed when the connection is gone
+	stopped bool
 }
 
 func NewConnection(conn *websocket.Conn) *Connection {
 	connection := &Connection {
 		connection: conn,
+		outbox: []interface{}{},
+		wakeup: make(chan bool, 1),
+		done: make(chan bool),
 	}
+	CONNECTIONS_LOCK.Lock()
+	CONNECTIONS[connection] = true
+	CONNECTIONS_LOCK.Unlock()
+	go connection.runWriter()
 	return connection
 }
 
@@ -29,7 +74,169 @@ func NewConnection(conn *websocket.Conn) *Connection {
 
 // communication related stuff
 
-func (this *Connection) SendMessage(message interface{}) error {
+// queues the message, a single writer sends the messages in order
+// what happens when the outbox is full depends on the OUTBOX_POLICY
+func (this *Connection) SendMessage(message interface{}) {
+	this.outboxLock.Lock()
+	if this.stopped {
+		this.outboxLock.Unlock()
+		return
+	}
+	_, isLobby := message.(*msg.LobbyMessage)
+	if isLobby && OUTBOX_POLICY == OUTBOX_COALESCE && this.removeLobbyMessages() > 0 {
+		OUTBOX_METRICS.Add("coalesced", 1)
+	}
+	if len(this.outbox) >= OUTBOX_SIZE {
+		// states are never dropped, viewers that receive deltas would no longer be able to apply them
+		if OUTBOX_POLICY == OUTBOX_DISCONNECT || !this.dropOldest() {
+			this.outboxLock.Unlock()
+			log.Warnf("Outbox of [%s] is full, disconnecting", this.getClientName())
+			OUTBOX_METRICS.Add("disconnects", 1)
+			this.getConnection().Close() // the reader notices and handles the disconnect
+			return
+		}
+	}
+	this.outbox = append(this.outbox, message)
+	OUTBOX_METRICS.Add("depth", 1)
+	OUTBOX_METRICS.Add("queued", 1)
+	if len(this.outbox) > this.maxDepth {
+		this.maxDepth = len(this.outbox)
+		CONNECTIONS_LOCK.Lock()
+		if int64(this.maxDepth) > OUTBOX_MAX_DEPTH.Value() {
+			OUTBOX_MAX_DEPTH.Set(int64(this.maxDepth))
+		}
+		CONNECTIONS_LOCK.Unlock()
+	}
+	this.outboxLock.Unlock()
+
+	select {
+		case this.wakeup <- true:
+		default: // the writer is already awake
+	}
+}
+
+// has to be called with the outbox lock held, returns the number of removed messages
+func (this *Connection) removeLobbyMessages() int {
+	kept := this.outbox[:0]
+	for _,message := range this.outbox {
+		if _, isLobby := message.(*msg.LobbyMessage); !isLobby {
+			kept = append(kept, message)
+		}
+	}
+	removed := len(this.outbox) - len(kept)
+	for i := len(kept); i < len(this.outbox); i++ {
+		this.outbox[i] = nil
+	}
+	this.outbox = kept
+	OUTBOX_METRICS.Add("depth", int64(-removed))
+	return removed
+}
+
+// has to be called with the outbox lock held, returns false when every queued message is a state
+func (this *Connection) dropOldest() bool {
+	for i,message := range this.outbox {
+		if isStateMessage(message) {
+			continue
+		}
+		copy(this.outbox[i:], this.outbox[i+1:])
+		this.outbox[len(this.outbox)-1] = nil
+		this.outbox = this.outbox[:len(this.outbox)-1]
+		this.dropped++
+		OUTBOX_METRICS.Add("depth", -1)
+		OUTBOX_METRICS.Add("dropped", 1)
+		return true
+	}
+	return false
+}
+
+func isStateMessage(message interface{}) bool {
+	switch message.(type) {
+		case *msg.StateMessageOut, *msg.DeltaMessage, *msg.HistoryMessage:
+			return true
+	}
+	return false
+}
+
+// takes the oldest message from the outbox, nil when it is empty
+func (this *Connection) takeMessage() interface{} {
+	this.outboxLock.Lock()
+	defer this.outboxLock.Unlock()
+	if len(this.outbox) == 0 {
+		return nil
+	}
+	message := this.outbox[0]
+	this.outbox[0] = nil
+	this.outbox = this.outbox[1:]
+	OUTBOX_METRICS.Add("depth", -1)
+	return message
+}
+
+func (this *Connection) runWriter() {
+	for {
+		message := this.takeMessage()
+		if message == nil {
+			select {
+				case <- this.wakeup:
+					continue
+				case <- this.done:
+					return
+			}
+		}
+		err := this.write(message)
+		if err != nil {
+			log.Errorf("Unexpected error while sending message to [%s] : [%s]", this.getClientName(), err)
+			continue
+		}
+		OUTBOX_METRICS.Add("sent", 1)
+	}
+}
+
+// stops the writer, messages that were not sent yet are dropped
+func (this *Connection) stopWriter() {
+	this.outboxLock.Lock()
+	defer this.outboxLock.Unlock()
+	if this.stopped {
+		return
+	}
+	this.stopped = true
+	OUTBOX_METRICS.Add("depth", int64(-len(this.outbox)))
+	this.outbox = nil
+	close(this.done)
+
+	CONNECTIONS_LOCK.Lock()
+	delete(CONNECTIONS, this)
+	CONNECTIONS_LOCK.Unlock()
+}
+
+func (this *Connection) getOutboxMetrics() *OutboxMetrics {
+	name := this.getClientName()
+	this.outboxLock.Lock()
+	defer this.outboxLock.Unlock()
+	return &OutboxMetrics {
+		Client: name,
+		Depth: len(this.outbox),
+		MaxDepth: this.maxDepth,
+		Dropped: this.dropped,
+	}
+}
+
+// the metrics of every open connection
+func getOutboxMetrics() interface{} {
+	CONNECTIONS_LOCK.Lock()
+	connections := []*Connection{}
+	for connection := range CONNECTIONS {
+		connections = append(connections, connection)
+	}
+	CONNECTIONS_LOCK.Unlock()
+
+	result := []*OutboxMetrics{}
+	for _,connection := range connections {
+		result = append(result, connection.getOutboxMetrics())
+	}
+	return result
+}
+
+func (this *Connection) write(message interface{}) error {
 	text, err := json.Marshal(message)
 	if err != nil {
 		return errors.New(fmt.Sprintf("Unexpected error while parsing message to json : [%s] : [%s]", err, message))
@@ -78,6 +285,7 @@ func (this *Connection) HandleMessage(raw []byte) {
 }
 
 func (this *Connection) HandleDisconnect() {
+	this.stopWriter()
 	client := this.getClient()
 	if client == nil {
 		return
@@ -101,6 +309,14 @@ func (this *Connection) SetClient(client *Client) {
 	this.client = client
 }
 
+func (this *Connection) getClientName() string {
+	client := this.getClient()
+	if client == nil {
+		return ""
+	}
+	return client.GetName()
+}
+
 func (this *Connection) getConnection() *websocket.Conn {
 	this.RLock()
 	defer this.RUnlock()
@@ -4,20 +4,31 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"regexp"
 	"strconv"
+	"strings"
+	"time"
+	sync "github.com/sasha-s/go-deadlock"
 	log "github.com/sirupsen/logrus"
 	msg "github.com/Project-Wartemis/pw-backend/internal/message"
+	"github.com/Project-Wartemis/pw-backend/internal/patch"
+	"github.com/Project-Wartemis/pw-backend/internal/storage"
 	"github.com/Project-Wartemis/pw-backend/internal/util"
 )
 
 const (
 	PLAYER_PREFIX = "{[(___"
 	PLAYER_SUFFIX = "___)]}"
+	FORMAT_FULL  = "full"
+	FORMAT_DELTA = "delta"
+	KEYFRAME_INTERVAL = 10 // viewers that receive deltas get the full state every this many turns
+	FORFEIT_ELIMINATE = "eliminate"
+	FORFEIT_REMOVE    = "remove"
 )
 
 var (
 	GAME_COUNTER util.SafeCounter
+	FORFEIT_POLICIES = []string{FORFEIT_ELIMINATE, FORFEIT_REMOVE}
+	ENGINE_RECONNECT_TIMEOUT = 30 * time.Second // running games are aborted when their engine does not reconnect in time
 )
 
 type stateConverter func(*msg.StateMessage) *msg.StateMessageOut
@@ -26,23 +37,141 @@ type Game struct {
 	Room
 	Id int           `json:"id"`
 	Engine *Client   `json:"engine"`
+	Creator *Client  `json:"creator"` // nil when created through the api
+	creatorToken string // the resume token of the creator, so it keeps control after a reconnect
 	Players []*Player  `json:"players"`
 	History *History `json:"-"`
 	Started bool     `json:"started"`
 	Stopped bool     `json:"stopped"`
+	MoveTimeout int  `json:"moveTimeout"` // in milliseconds, 0 means no timeout
+	Autoplay bool    `json:"autoplay"`
+	ReconnectTimeout int `json:"reconnectTimeout"` // in milliseconds, 0 means disconnected bots never forfeit
+	ForfeitPolicy string `json:"forfeitPolicy"` // what happens to a bot that did not reconnect in time
+	Paused bool      `json:"paused"`
+	Replay bool      `json:"replay"` // imported from a replay, can only be viewed
+	Result *msg.GameResult `json:"result"`
+	Aborted bool     `json:"aborted"` // stopped without the engine stopping it
+	AbortReason string `json:"abortReason,omitempty"`
+	lobby *Lobby
+	turn *Turn // the turn the bots are currently acting in
+	deliverLock sync.Mutex // states have to be delivered one at a time, in order
+	pending []*msg.StateMessage // states that are held back while paused
+	heldActions []interface{} // actions for the engine that are held back while paused
+	steps int // the number of states to deliver even though paused
+	deltaViewers map[int]bool // ids of the viewers that want deltas instead of full states
+	removed map[int]bool // ids of the players that were removed after a forfeit
+	engineTimer *time.Timer // aborts the game when the engine does not reconnect in time
+	stoppedAt time.Time // stopped games are archived a while after they stopped
+	archived bool // no longer kept in the lobby, only loaded from storage when requested
 }
 
 func NewGame(name string, engine *Client) *Game {
+	return newGame(GAME_COUNTER.GetNext(), name, engine)
+}
+
+func newGame(id int, name string, engine *Client) *Game {
 	room := NewRoom(name)
 	return &Game {
 		Room: *room,
-		Id: GAME_COUNTER.GetNext(),
+		Id: id,
 		Engine: engine,
 		Players: []*Player{},
 		History: NewHistory(),
 		Started: false,
 		Stopped: false,
+		Autoplay: true,
+		ReconnectTimeout: 0,
+		ForfeitPolicy: FORFEIT_ELIMINATE,
+		Paused: false,
+		Replay: false,
+		pending: []*msg.StateMessage{},
+		heldActions: []interface{}{},
+		steps: 0,
+		deltaViewers: map[int]bool{},
+		removed: map[int]bool{},
+	}
+}
+
+// a game that was loaded from storage
+// clients are shared between games, so they are looked up in or added to the given map
+func restoreGame(lobby *Lobby, record *storage.GameRecord, clients map[int]*Client) *Game {
+	restoreClient := func(record *storage.ClientRecord) *Client {
+		client, found := clients[record.Id]
+		if !found {
+			client = newRestoredClient(lobby, record)
+			clients[record.Id] = client
+		}
+		return client
+	}
+
+	game := newGame(record.Id, record.Name, restoreClient(record.Engine))
+	game.Started = record.Started
+	game.Stopped = record.Stopped
+	game.MoveTimeout = record.MoveTimeout
+	game.Autoplay = record.Autoplay
+	game.ReconnectTimeout = record.ReconnectTimeout
+	if record.ForfeitPolicy != "" {
+		game.ForfeitPolicy = record.ForfeitPolicy
+	}
+	game.Replay = record.Replay
+	game.Result = record.Result
+	game.Aborted = record.Aborted
+	game.AbortReason = record.AbortReason
+	game.stoppedAt = record.StoppedAt
+	if game.Stopped && game.stoppedAt.IsZero() {
+		game.stoppedAt = time.Now() // stored before games kept track of this
+	}
+	game.archived = record.Archived
+	if record.Creator != nil {
+		game.Creator = restoreClient(record.Creator)
+	}
+	for _,player := range record.Players {
+		restored := NewPlayer(player.Id, restoreClient(player.Client))
+		restored.Eliminated = player.Eliminated
+		game.Players = append(game.Players, restored)
+	}
+	game.History.restore(record.Turns)
+	return game
+}
+
+func (this *Game) toRecord() *storage.GameRecord {
+	this.RLock()
+	defer this.RUnlock()
+	players := []*storage.PlayerRecord{}
+	for _,player := range this.Players {
+		players = append(players, player.toRecord())
+	}
+	var creator *storage.ClientRecord
+	if this.Creator != nil {
+		creator = this.Creator.toRecord()
 	}
+	return &storage.GameRecord {
+		Id: this.Id,
+		Name: this.Name,
+		Engine: this.Engine.toRecord(),
+		Creator: creator,
+		Players: players,
+		Started: this.Started,
+		Stopped: this.Stopped,
+		MoveTimeout: this.MoveTimeout,
+		Autoplay: this.Autoplay,
+		ReconnectTimeout: this.ReconnectTimeout,
+		ForfeitPolicy: this.ForfeitPolicy,
+		Replay: this.Replay,
+		Result: this.Result,
+		Aborted: this.Aborted,
+		AbortReason: this.AbortReason,
+		StoppedAt: this.stoppedAt,
+		Archived: this.archived,
+	}
+}
+
+func (this *Game) save() {
+	lobby := this.getLobby()
+	if lobby == nil {
+		return
+	}
+	lobby.SaveGame(this)
 }
 
 func (this *Game) Start() error {
@@ -50,31 +179,188 @@ func (this *Game) Start() error {
 		return errors.New(fmt.Sprintf("Game [%d] has already started", this.GetId()))
 	}
 	this.setStarted(true)
+	this.setPaused(!this.GetAutoplay())
+	this.save()
 	players := this.GetPlayerIds()
 	message := msg.NewStartMessage(this.GetId(), players, PLAYER_PREFIX, PLAYER_SUFFIX)
 	this.getEngine().SendMessage(message)
 	return nil
 }
 
-func (this *Game) Stop() error {
+// the result is optional
+func (this *Game) Stop(stopResult *msg.StopResult) error {
 	if !this.GetStarted() {
 		return errors.New(fmt.Sprintf("Game [%d] has not started yet", this.GetId()))
 	}
-	if this.GetStopped() {
+	var result *msg.GameResult
+	if stopResult != nil {
+		var err error
+		result, err = this.convertResult(stopResult)
+		if err != nil {
+			return err
+		}
+	}
+	if !this.markStopped() {
 		return errors.New(fmt.Sprintf("Game [%d] has already stopped", this.GetId()))
 	}
-	this.setStopped(true)
-	message := msg.NewStopMessage(this.GetId())
-	this.BroadcastToType(TYPE_BOT, message)
+	this.clearEngineTimer()
+	this.setResult(result)
+	this.save()
+	this.flushPendingStates()
+	for _,player := range this.GetPlayers() {
+		player.ClearDeadline()
+		player.ClearReconnectTimer()
+	}
+	this.sendToPlayers(msg.NewStopMessage(this.GetId()))
+	if result != nil {
+		message := msg.NewResultMessage(this.GetId(), result)
+		this.sendToPlayers(message)
+		this.BroadcastToType(TYPE_VIEWER, message)
+	}
+	if lobby := this.getLobby(); lobby != nil {
+		lobby.HandleGameStopped(this)
+	}
 	return nil
 }
 
+// stops the game without a result
+func (this *Game) Abort(reason string) error {
+	if !this.GetStarted() {
+		return errors.New(fmt.Sprintf("Game [%d] has not started yet", this.GetId()))
+	}
+	if !this.markStopped() {
+		return errors.New(fmt.Sprintf("Game [%d] has already stopped", this.GetId()))
+	}
+	log.Warnf("Aborting game [%s] : [%s]", this.GetName(), reason)
+	this.setAborted(reason)
+	this.clearEngineTimer()
+	this.save()
+	for _,player := range this.GetPlayers() {
+		player.ClearDeadline()
+		player.ClearReconnectTimer()
+	}
+	message := msg.NewAbortedMessage(this.GetId(), reason)
+	this.sendToPlayers(message)
+	this.BroadcastToType(TYPE_VIEWER, message)
+	if lobby := this.getLobby(); lobby != nil {
+		lobby.HandleGameStopped(this)
+		lobby.TriggerUpdated()
+	}
+	return nil
+}
+
+func (this *Game) Pause() error {
+	if !this.GetStarted() || this.GetStopped() {
+		return errors.New(fmt.Sprintf("Game [%d] is not running", this.GetId()))
+	}
+	if this.GetPaused() {
+		return errors.New(fmt.Sprintf("Game [%d] is already paused", this.GetId()))
+	}
+	this.setPaused(true)
+	return nil
+}
+
+func (this *Game) Resume() error {
+	if !this.GetStarted() || this.GetStopped() {
+		return errors.New(fmt.Sprintf("Game [%d] is not running", this.GetId()))
+	}
+	if !this.GetPaused() {
+		return errors.New(fmt.Sprintf("Game [%d] is not paused", this.GetId()))
+	}
+	this.setPaused(false)
+	this.releaseActions()
+	this.flushPendingStates()
+	return nil
+}
+
+// sends the held actions to the engine, and delivers a single held state or the next one the engine sends
+// the engine does not know about the pause, it only has to wait a bit longer
+func (this *Game) Step() error {
+	if !this.GetStarted() || this.GetStopped() {
+		return errors.New(fmt.Sprintf("Game [%d] is not running", this.GetId()))
+	}
+	if !this.GetPaused() {
+		return errors.New(fmt.Sprintf("Game [%d] is not paused", this.GetId()))
+	}
+	this.releaseActions()
+
+	this.deliverLock.Lock()
+	defer this.deliverLock.Unlock()
+	this.Lock()
+	if len(this.pending) == 0 {
+		this.steps++
+		this.Unlock()
+		return nil
+	}
+	message := this.pending[0]
+	this.pending = this.pending[1:]
+	this.Unlock()
+	this.deliverState(message)
+	return nil
+}
+
+// replaces the padded ids of the engine with player ids
+func (this *Game) convertResult(stopResult *msg.StopResult) (*msg.GameResult, error) {
+	result := &msg.GameResult {
+		Winners: []int{},
+		Scores: map[int]float64{},
+		Ranks: map[int]int{},
+		Reason: stopResult.Reason,
+	}
+	for _,paddedId := range stopResult.Winners {
+		id, removed, err := this.parseResultId(paddedId)
+		if err != nil {
+			return nil, err
+		}
+		if !removed {
+			result.Winners = append(result.Winners, id)
+		}
+	}
+	for paddedId,score := range stopResult.Scores {
+		id, removed, err := this.parseResultId(paddedId)
+		if err != nil {
+			return nil, err
+		}
+		if !removed {
+			result.Scores[id] = score
+		}
+	}
+	for paddedId,rank := range stopResult.Ranks {
+		id, removed, err := this.parseResultId(paddedId)
+		if err != nil {
+			return nil, err
+		}
+		if !removed {
+			result.Ranks[id] = rank
+		}
+	}
+	return result, nil
+}
+
+// the engine can still mention players that were removed after a forfeit, they are left out of the result
+func (this *Game) parseResultId(paddedId string) (int, bool, error) {
+	id, err := this.parsePaddedId(paddedId)
+	if err == nil {
+		return id, false, nil
+	}
+	for _,id := range this.getRemoved() {
+		if this.getPaddedId(id) == paddedId {
+			return id, true, nil
+		}
+	}
+	return 0, false, err
+}
+
 
 
 // communication related stuff
 
 func (this *Game) HandleReconnect(client *Client) {
 	// resend the last history state
+	if client == this.getEngine() {
+		this.handleEngineReconnect()
+		return
+	}
 	if client.GetType() != TYPE_BOT {
 		return // only to bots
 	}
@@ -82,6 +368,12 @@ func (this *Game) HandleReconnect(client *Client) {
 	if len(players) == 0 {
 		return // not a player in this game
 	}
+	for _,player := range players {
+		if player.HandleReconnect() {
+			log.Infof("Player [%d] in game [%s] reconnected", player.GetId(), this.GetName())
+			this.getEngine().SendMessage(msg.NewReconnectedMessage(this.GetId(), this.getPaddedId(player.GetId())))
+		}
+	}
 
 	message := this.GetHistory().GetLatest()
 	if message == nil {
@@ -94,38 +386,333 @@ func (this *Game) HandleReconnect(client *Client) {
 	}
 }
 
+// the game is aborted when the engine does not reconnect in time
+func (this *Game) HandleEngineDisconnect() {
+	if !this.GetStarted() || this.GetStopped() {
+		return
+	}
+	log.Warnf("The engine of game [%s] disconnected", this.GetName())
+	this.Lock()
+	defer this.Unlock()
+	if this.engineTimer != nil {
+		this.engineTimer.Stop()
+	}
+	this.engineTimer = time.AfterFunc(ENGINE_RECONNECT_TIMEOUT, func() {
+		this.Abort(fmt.Sprintf("The engine did not reconnect within [%s]", ENGINE_RECONNECT_TIMEOUT))
+	})
+}
+
+// the engine gets the start message and the latest state again, so it can continue
+func (this *Game) handleEngineReconnect() {
+	if !this.clearEngineTimer() || this.GetStopped() {
+		return
+	}
+	log.Infof("The engine of game [%s] reconnected", this.GetName())
+	engine := this.getEngine()
+	engine.SendMessage(msg.NewStartMessage(this.GetId(), this.GetPlayerIds(), PLAYER_PREFIX, PLAYER_SUFFIX))
+	latest := this.GetHistory().GetLatest()
+	if latest != nil {
+		engine.SendMessage(latest)
+	}
+}
+
+// returns false if there was no timer running
+func (this *Game) clearEngineTimer() bool {
+	this.Lock()
+	defer this.Unlock()
+	if this.engineTimer == nil {
+		return false
+	}
+	this.engineTimer.Stop()
+	this.engineTimer = nil
+	return true
+}
+
+// tells the engine, the players of the bot forfeit when it does not reconnect in time
+func (this *Game) HandleDisconnect(client *Client) {
+	if !this.GetStarted() || this.GetStopped() {
+		return
+	}
+	timeout := time.Duration(this.GetReconnectTimeout()) * time.Millisecond
+	for _,player := range this.getPlayersByClient(client) {
+		if player.GetEliminated() {
+			continue
+		}
+		log.Infof("Player [%d] in game [%s] disconnected", player.GetId(), this.GetName())
+		this.getEngine().SendMessage(msg.NewDisconnectedMessage(this.GetId(), this.getPaddedId(player.GetId())))
+		forfeiter := player
+		player.HandleDisconnect(timeout, func() {
+			this.forfeit(forfeiter)
+		})
+	}
+}
+
+// removes or eliminates a player, depending on the forfeit policy
+func (this *Game) forfeit(player *Player) {
+	if this.GetStopped() {
+		return
+	}
+	policy := this.GetForfeitPolicy()
+	log.Infof("Player [%d] in game [%s] did not reconnect in time, policy [%s]", player.GetId(), this.GetName(), policy)
+
+	player.ClearDeadline()
+	if policy == FORFEIT_REMOVE {
+		this.RemovePlayer(player)
+		this.setRemoved(player.GetId())
+	} else {
+		player.setEliminated(true)
+	}
+	this.save()
+	this.getEngine().SendMessage(msg.NewForfeitMessage(this.GetId(), this.getPaddedId(player.GetId()), policy))
+
+	turn := this.getTurn()
+	if turn != nil && turn.Skip(player.GetId()) {
+		this.flushActions(turn) // the other players should not wait on the player that is gone
+	}
+	if lobby := this.getLobby(); lobby != nil {
+		lobby.TriggerUpdated()
+	}
+}
+
 func (this *Game) HandleStateMessage(message *msg.StateMessage) {
-	for _,player := range this.Players {
+	if !this.validateState(message) {
+		return
+	}
+
+	this.deliverLock.Lock()
+	defer this.deliverLock.Unlock()
+	if this.holdState(message) {
+		log.Debugf("Holding state for turn [%d] in game [%s] while paused", message.Turn, this.GetName())
+		return
+	}
+	this.deliverState(message)
+}
+
+// sends the engine an error and returns false if the state is not acceptable
+func (this *Game) validateState(message *msg.StateMessage) bool {
+	if len(message.States) > 0 {
+		for _,player := range this.GetPlayers() {
+			paddedId := this.getPaddedId(player.GetId())
+			if _, found := message.States[paddedId]; !found {
+				this.getEngine().SendError(fmt.Sprintf("State for game [%d] has no state for player [%s]", this.GetId(), paddedId))
+				return false
+			}
+		}
+	}
+
+	if !this.validateStateSchema("state", message.State) {
+		return false
+	}
+	for paddedId,state := range message.States {
+		if !this.validateStateSchema(fmt.Sprintf("state of player [%s]", paddedId), state) {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *Game) validateStateSchema(description string, state json.RawMessage) bool {
+	schema := this.getEngine().GetStateSchema()
+	if schema == nil {
+		return true
+	}
+	mistakes, err := schema.Validate(state)
+	if err != nil {
+		this.getEngine().SendError(fmt.Sprintf("Could not validate %s for game [%d]: [%s]", description, this.GetId(), err))
+		return false
+	}
+	if len(mistakes) > 0 {
+		this.getEngine().SendValidationErrors(fmt.Sprintf("The %s for game [%d] does not match the state schema", description, this.GetId()), mistakes)
+		return false
+	}
+	return true
+}
+
+// only call while holding the deliverLock
+func (this *Game) deliverState(message *msg.StateMessage) {
+	if previous := this.getTurn(); previous != nil {
+		previous.End()
+		this.flushActions(previous) // the engine did not wait for every player, it still gets the actions it did not receive yet
+	}
+	this.setTurn(NewTurn(message.Turn, this.getMovers(message)))
+	for _,player := range this.GetPlayers() {
 		this.sendStateMessageToPlayer(player, message)
 	}
 	broadcast := this.makeStateConverter(nil)(message)
-	this.BroadcastToType(TYPE_VIEWER, broadcast)
+	this.broadcastStateToViewers(broadcast)
 	this.GetHistory().Add(message)
 	this.GetHistory().AddConverted(broadcast)
+	if this.getLobby() != nil {
+		this.getLobby().SaveTurn(this, message, broadcast)
+	}
+}
+
+// sends the full state, or the changes since the previous turn to viewers that asked for it
+func (this *Game) broadcastStateToViewers(state *msg.StateMessageOut) {
+	var delta *msg.DeltaMessage
+	previous := this.GetHistory().GetLatestConverted()
+	if previous != nil && previous.Turn == state.Turn - 1 && state.Turn % KEYFRAME_INTERVAL != 0 {
+		operations, err := patch.Diff(previous.State, state.State)
+		if err != nil {
+			log.Warnf("Could not compute delta for turn [%d] in game [%s] : [%s]", state.Turn, this.GetName(), err)
+		} else {
+			delta = msg.NewDeltaMessage(this.GetId(), state.Turn, previous.Turn, operations)
+		}
+	}
+
+	for _,client := range this.GetClients() {
+		if client.GetType() != TYPE_VIEWER {
+			continue
+		}
+		if delta != nil && this.wantsDelta(client) {
+			client.SendMessage(delta)
+		} else {
+			client.SendMessage(state)
+		}
+	}
+}
+
+// returns true if the state has to wait until the game is resumed or stepped
+func (this *Game) holdState(message *msg.StateMessage) bool {
+	this.Lock()
+	defer this.Unlock()
+	if !this.Paused || this.Stopped {
+		return false
+	}
+	if this.steps > 0 {
+		this.steps--
+		return false
+	}
+	this.pending = append(this.pending, message)
+	return true
+}
+
+func (this *Game) flushPendingStates() {
+	this.deliverLock.Lock()
+	defer this.deliverLock.Unlock()
+	this.Lock()
+	pending := this.pending
+	this.pending = []*msg.StateMessage{}
+	this.Unlock()
+	for _,message := range pending {
+		this.deliverState(message)
+	}
+}
+
+// returns the ids of the players that are asked to move
+func (this *Game) getMovers(message *msg.StateMessage) []int {
+	result := []int{}
+	if this.GetStopped() {
+		return result
+	}
+	for _,player := range this.GetPlayers() {
+		if player.GetEliminated() {
+			continue
+		}
+		if util.Includes(message.Players, this.getPaddedId(player.GetId())) {
+			result = append(result, player.GetId())
+		}
+	}
+	return result
 }
 
 func (this *Game) makeStateConverter(player *Player) stateConverter {
 	paddedPlayerId := this.getPaddedId(-1)
 	playerKey := ""
+	rewriter := VIEWER_REWRITER
 	if player != nil {
 		paddedPlayerId = this.getPaddedId(player.GetId())
 		playerKey = player.GetKey()
+		rewriter = player.getRewriter()
 	}
-	regex1 := regexp.MustCompile(regexp.QuoteMeta("\"" + paddedPlayerId + "\""))
-	regex2 := regexp.MustCompile(regexp.QuoteMeta("\"" + PLAYER_PREFIX) + "(\\d+)" + regexp.QuoteMeta(PLAYER_SUFFIX + "\""))
 
 	return func(message *msg.StateMessage) *msg.StateMessageOut {
-		state := string(message.State)
-		move := !this.GetStopped() && util.Includes(message.Players, paddedPlayerId)
-		state = regex1.ReplaceAllString(state, "1")
-		state = regex2.ReplaceAllString(state, "$1")
-		return msg.NewStateMessageOut(message.Game, playerKey, message.Turn, move, state)
+		state := message.State
+		if own, found := message.States[paddedPlayerId]; found && player != nil {
+			state = own // only the player gets to see their own state, viewers see everything
+		}
+		move := !this.GetStopped() && (player == nil || !player.GetEliminated()) && util.Includes(message.Players, paddedPlayerId)
+		return msg.NewStateMessageOut(message.Game, playerKey, message.Turn, move, string(rewriter.Rewrite(state)))
+	}
+}
+
+// sends the message once to every client that controls a player in this game
+func (this *Game) sendToPlayers(message interface{}) {
+	sent := map[*Client]bool{}
+	for _,player := range this.GetPlayers() {
+		client := player.GetClient()
+		if sent[client] {
+			continue
+		}
+		sent[client] = true
+		client.SendMessage(message)
 	}
 }
 
 func (this *Game) sendStateMessageToPlayer(player *Player, message *msg.StateMessage) {
 	outgoing := this.makeStateConverter(player)(message)
 	player.GetClient().SendMessage(outgoing)
+	if outgoing.Move {
+		this.startMoveDeadline(player, outgoing.Turn)
+	}
+}
+
+func (this *Game) startMoveDeadline(player *Player, turn int) {
+	timeout := this.GetMoveTimeout()
+	if timeout <= 0 {
+		return
+	}
+	player.StartDeadline(turn, time.Duration(timeout) * time.Millisecond, func() {
+		this.handleMoveTimeout(player, turn)
+	})
+}
+
+func (this *Game) handleMoveTimeout(player *Player, turn int) {
+	if this.GetStopped() {
+		return
+	}
+	current := this.getTurn()
+	if current == nil || current.GetNumber() != turn || !current.Skip(player.GetId()) {
+		return // the game moved on, or the player acted after all
+	}
+	log.Infof("Player [%d] in game [%s] did not act in time for turn [%d]", player.GetId(), this.GetName(), turn)
+	this.getEngine().SendMessage(msg.NewTimeoutMessage(this.GetId(), this.getPaddedId(player.GetId()), turn))
+	player.GetClient().SendError(fmt.Sprintf("You did not send an action for turn [%d] in game [%d] within [%d] ms", turn, this.GetId(), this.GetMoveTimeout()))
+	this.flushActions(current)
+}
+
+// sends all actions of the turn at once to an engine that asked for it, once every mover is done
+func (this *Game) flushActions(turn *Turn) {
+	if !this.getEngine().GetBatchActions() {
+		return
+	}
+	actions := turn.TakeActions()
+	if actions == nil {
+		return
+	}
+	this.sendActionsToEngine(msg.NewActionsMessage(this.GetId(), turn.GetNumber(), actions))
+}
+
+// actions wait while the game is paused, until it is stepped or resumed
+func (this *Game) sendActionsToEngine(message interface{}) {
+	this.Lock()
+	if this.Paused && !this.Stopped {
+		this.heldActions = append(this.heldActions, message)
+		this.Unlock()
+		return
+	}
+	this.Unlock()
+	this.getEngine().SendMessage(message)
+}
+
+func (this *Game) releaseActions() {
+	this.Lock()
+	held := this.heldActions
+	this.heldActions = []interface{}{}
+	this.Unlock()
+	for _,message := range held {
+		this.getEngine().SendMessage(message)
+	}
 }
 
 func (this *Game) HandleActionMessage(message *msg.ActionMessage) {
@@ -141,8 +728,38 @@ func (this *Game) HandleActionMessage(message *msg.ActionMessage) {
 		player.GetClient().SendError(fmt.Sprintf("Game [%d] has already stopped", this.GetId()))
 		return
 	}
+	schema := this.getEngine().GetActionSchema()
+	if schema != nil {
+		mistakes, err := schema.Validate(message.Action)
+		if err != nil {
+			player.GetClient().SendError(fmt.Sprintf("Could not validate action for game [%d]: [%s]", this.GetId(), err))
+			return
+		}
+		if len(mistakes) > 0 {
+			player.GetClient().SendValidationErrors(fmt.Sprintf("Action for game [%d] does not match the action schema of the engine", this.GetId()), mistakes)
+			return
+		}
+	}
+	turn := this.getTurn()
+	if turn == nil {
+		player.GetClient().SendError(fmt.Sprintf("Game [%d] has no turn in progress", this.GetId()))
+		return
+	}
 	message.Player = this.getPaddedId(player.GetId())
-	this.getEngine().SendMessage(message)
+	message.Key = "" // the engine should not be able to act for the bot
+	err := turn.AddAction(player.GetId(), message)
+	if err != nil {
+		player.GetClient().SendError(fmt.Sprintf("Action rejected in game [%d]: [%s]", this.GetId(), err))
+		return
+	}
+	number := turn.GetNumber()
+	message.Turn = &number
+	player.ClearDeadline()
+	if this.getEngine().GetBatchActions() {
+		this.flushActions(turn)
+		return
+	}
+	this.sendActionsToEngine(message)
 }
 
 // getters and setters
@@ -157,23 +774,80 @@ func (this *Game) getPaddedId(id int) string {
 	return PLAYER_PREFIX + strconv.Itoa(id) + PLAYER_SUFFIX
 }
 
+// returns the id of the player with the given padded id
+func (this *Game) parsePaddedId(paddedId string) (int, error) {
+	if !strings.HasPrefix(paddedId, PLAYER_PREFIX) || !strings.HasSuffix(paddedId, PLAYER_SUFFIX) {
+		return 0, errors.New(fmt.Sprintf("Invalid player [%s]", paddedId))
+	}
+	id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(paddedId, PLAYER_PREFIX), PLAYER_SUFFIX))
+	if err != nil || this.getPlayerById(id) == nil {
+		return 0, errors.New(fmt.Sprintf("Player [%s] not found in game [%d]", paddedId, this.GetId()))
+	}
+	return id, nil
+}
+
+func (this *Game) GetCreator() *Client {
+	this.RLock()
+	defer this.RUnlock()
+	return this.Creator
+}
+
+func (this *Game) SetCreator(creator *Client) {
+	token := creator.getResumeToken()
+	this.Lock()
+	defer this.Unlock()
+	this.Creator = creator
+	this.creatorToken = token
+}
+
+func (this *Game) getCreatorToken() string {
+	this.RLock()
+	defer this.RUnlock()
+	return this.creatorToken
+}
+
+// only the creator of a game and admins can invite bots and control the game
+// without authentication, anyone can control games without a creator, like the ones created through the api
+func (this *Game) CanControl(client *Client) bool {
+	if client.IsAdmin() {
+		return true
+	}
+	creator := this.GetCreator()
+	if creator == nil {
+		lobby := this.getLobby()
+		return lobby != nil && !lobby.GetAuthenticator().IsEnabled()
+	}
+	if creator == client {
+		return true
+	}
+	token := this.getCreatorToken()
+	return token != "" && client.MatchesResumeToken(token)
+}
+
 func (this *Game) getEngine() *Client {
 	this.RLock()
 	defer this.RUnlock()
 	return this.Engine
 }
 
-func (this *Game) AddPlayer(client *Client) {
+func (this *Game) AddPlayer(client *Client) error {
 	if this.GetStarted() {
-		return
+		return errors.New(fmt.Sprintf("Game [%d] has already started", this.GetId()))
+	}
+	err := client.CanPlay(this.getEngine())
+	if err != nil {
+		return err
 	}
 
 	log.Infof("Adding player [%s] to game [%s]", client.GetName(), this.GetName())
 
 	player := NewPlayer(this.GetNextPlayerId(), client)
 	this.Lock()
-	defer this.Unlock()
 	this.Players = append(this.Players, player)
+	this.Unlock()
+
+	this.save()
+	return nil
 }
 
 func (this *Game) RemovePlayer(player *Player) {
@@ -191,6 +865,41 @@ func (this *Game) RemovePlayer(player *Player) {
 	}
 }
 
+func (this *Game) getRemoved() []int {
+	this.RLock()
+	defer this.RUnlock()
+	result := []int{}
+	for id := range this.removed {
+		result = append(result, id)
+	}
+	return result
+}
+
+func (this *Game) setRemoved(id int) {
+	this.Lock()
+	defer this.Unlock()
+	this.removed[id] = true
+}
+
+func (this *Game) GetPlayers() []*Player {
+	this.RLock()
+	defer this.RUnlock()
+	result := make([]*Player, len(this.Players))
+	copy(result, this.Players)
+	return result
+}
+
+func (this *Game) getPlayerById(id int) *Player {
+	this.RLock()
+	defer this.RUnlock()
+	for _,player := range this.Players {
+		if player.GetId() == id {
+			return player
+		}
+	}
+	return nil
+}
+
 func (this *Game) GetPlayerIds() []int {
 	this.RLock()
 	defer this.RUnlock()
@@ -211,9 +920,7 @@ func (this *Game) GetNextPlayerId() int {
 }
 
 func (this *Game) GetPlayerByKey(key string) *Player {
-	this.RLock()
-	defer this.RUnlock()
-	for _,player := range this.Players {
+	for _,player := range this.GetPlayers() {
 		if player.GetKey() == key {
 			return player
 		}
@@ -234,6 +941,18 @@ func (this *Game) getPlayersByClient(client *Client) []*Player {
 	return result
 }
 
+func (this *Game) getLobby() *Lobby {
+	this.RLock()
+	defer this.RUnlock()
+	return this.lobby
+}
+
+func (this *Game) setLobby(lobby *Lobby) {
+	this.Lock()
+	defer this.Unlock()
+	this.lobby = lobby
+}
+
 func (this *Game) GetHistory() *History {
 	this.RLock()
 	defer this.RUnlock()
@@ -262,6 +981,171 @@ func (this *Game) setStopped(stopped bool) {
 	this.Lock()
 	defer this.Unlock()
 	this.Stopped = stopped
+	if stopped && this.stoppedAt.IsZero() {
+		this.stoppedAt = time.Now()
+	}
+}
+
+// stops the game, returns false when it was already stopped
+// the check and the change happen under one lock, so a game can only be stopped once
+func (this *Game) markStopped() bool {
+	this.Lock()
+	defer this.Unlock()
+	if this.Stopped {
+		return false
+	}
+	this.Stopped = true
+	if this.stoppedAt.IsZero() {
+		this.stoppedAt = time.Now()
+	}
+	return true
+}
+
+func (this *Game) GetStoppedAt() time.Time {
+	this.RLock()
+	defer this.RUnlock()
+	return this.stoppedAt
+}
+
+func (this *Game) GetArchived() bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.archived
+}
+
+func (this *Game) setArchived(archived bool) {
+	this.Lock()
+	defer this.Unlock()
+	this.archived = archived
+}
+
+func (this *Game) GetMoveTimeout() int {
+	this.RLock()
+	defer this.RUnlock()
+	return this.MoveTimeout
+}
+
+func (this *Game) SetMoveTimeout(timeout int) {
+	this.Lock()
+	defer this.Unlock()
+	this.MoveTimeout = timeout
+}
+
+func (this *Game) getTurn() *Turn {
+	this.RLock()
+	defer this.RUnlock()
+	return this.turn
+}
+
+func (this *Game) setTurn(turn *Turn) {
+	this.Lock()
+	defer this.Unlock()
+	this.turn = turn
+}
+
+func (this *Game) GetAutoplay() bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.Autoplay
+}
+
+func (this *Game) SetAutoplay(autoplay bool) {
+	this.Lock()
+	defer this.Unlock()
+	this.Autoplay = autoplay
+}
+
+func (this *Game) GetReconnectTimeout() int {
+	this.RLock()
+	defer this.RUnlock()
+	return this.ReconnectTimeout
+}
+
+func (this *Game) SetReconnectTimeout(reconnectTimeout int) {
+	this.Lock()
+	defer this.Unlock()
+	this.ReconnectTimeout = reconnectTimeout
+}
+
+func (this *Game) GetForfeitPolicy() string {
+	this.RLock()
+	defer this.RUnlock()
+	return this.ForfeitPolicy
+}
+
+func (this *Game) SetForfeitPolicy(policy string) error {
+	if !util.Includes(FORFEIT_POLICIES, policy) {
+		return errors.New(fmt.Sprintf("Invalid forfeit policy [%s]", policy))
+	}
+	this.Lock()
+	defer this.Unlock()
+	this.ForfeitPolicy = policy
+	return nil
+}
+
+func (this *Game) GetPaused() bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.Paused
+}
+
+func (this *Game) setPaused(paused bool) {
+	this.Lock()
+	defer this.Unlock()
+	this.Paused = paused
+	this.steps = 0
+}
+
+func (this *Game) GetReplay() bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.Replay
+}
+
+func (this *Game) SetViewerFormat(client *Client, format string) {
+	this.Lock()
+	defer this.Unlock()
+	if format == FORMAT_DELTA {
+		this.deltaViewers[client.GetId()] = true
+	} else {
+		delete(this.deltaViewers, client.GetId())
+	}
+}
+
+func (this *Game) wantsDelta(client *Client) bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.deltaViewers[client.GetId()]
+}
+
+func (this *Game) RemoveClient(client *Client) {
+	this.Room.RemoveClient(client)
+	this.SetViewerFormat(client, FORMAT_FULL)
+}
+
+func (this *Game) GetAborted() bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.Aborted
+}
+
+func (this *Game) setAborted(reason string) {
+	this.Lock()
+	defer this.Unlock()
+	this.Aborted = true
+	this.AbortReason = reason
+}
+
+func (this *Game) GetResult() *msg.GameResult {
+	this.RLock()
+	defer this.RUnlock()
+	return this.Result
+}
+
+func (this *Game) setResult(result *msg.GameResult) {
+	this.Lock()
+	defer this.Unlock()
+	this.Result = result
 }
 
 
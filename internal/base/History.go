@@ -4,6 +4,7 @@ import (
 	"fmt"
 	sync "github.com/sasha-s/go-deadlock"
 	msg "github.com/Project-Wartemis/pw-backend/internal/message"
+	"github.com/Project-Wartemis/pw-backend/internal/storage"
 )
 
 type History struct {
@@ -19,6 +20,18 @@ func NewHistory() *History {
 	}
 }
 
+func (this *History) restore(turns []*storage.TurnRecord) {
+	for _,turn := range turns {
+		if turn == nil || turn.Converted == nil {
+			continue // nothing was sent to viewers for this turn
+		}
+		if turn.Message != nil {
+			this.Add(turn.Message)
+		}
+		this.AddConverted(turn.Converted)
+	}
+}
+
 
 
 // communication related stuff
@@ -29,31 +42,32 @@ func (this *History) SendAllToViewer(client *Client) {
 	if len(this.messagesConverted) == 0 {
 		return
 	}
-	client.SendMessage(msg.NewHistoryMessage(this.messagesConverted))
+	client.SendMessage(msg.NewHistoryMessage(this.messagesConverted, len(this.messagesConverted)))
 }
 
-// unused
-func (this *History) SendTurnsToViewer(client *Client, from int, to int) {
+// sends only the latest turn, viewers can request the rest in chunks
+func (this *History) SendLatestToViewer(client *Client) {
 	this.RLock()
 	defer this.RUnlock()
-	from = max(0, from)
-	to = min(to+1, len(this.messagesConverted))
-	if to <= from {
+	turns := len(this.messagesConverted)
+	if turns == 0 {
 		return
 	}
-	slice := this.messagesConverted[from:to]
-	client.SendMessage(msg.NewHistoryMessage(slice))
+	client.SendMessage(msg.NewHistoryMessage(this.messagesConverted[turns-1:], turns))
 }
 
-// unused
-func (this *History) SendTurnToViewer(client *Client, turn int) {
+// both from and to are inclusive
+func (this *History) SendTurnsToViewer(client *Client, from int, to int) {
 	this.RLock()
 	defer this.RUnlock()
-	if turn >= len(this.messagesConverted) {
-		client.SendError(fmt.Sprintf("Turn %d is not available", turn))
+	start := max(0, from)
+	end := min(to+1, len(this.messagesConverted))
+	if end <= start {
+		client.SendError(fmt.Sprintf("Turns [%d] to [%d] are not available", from, to))
 		return
 	}
-	client.SendMessage(this.messagesConverted[turn])
+	slice := this.messagesConverted[start:end]
+	client.SendMessage(msg.NewHistoryMessage(slice, len(this.messagesConverted)))
 }
 
 
@@ -86,6 +100,23 @@ func (this *History) AddConverted(message *msg.StateMessageOut) {
 	this.messagesConverted[message.Turn] = message
 }
 
+func (this *History) GetAllConverted() []*msg.StateMessageOut {
+	this.RLock()
+	defer this.RUnlock()
+	result := make([]*msg.StateMessageOut, len(this.messagesConverted))
+	copy(result, this.messagesConverted)
+	return result
+}
+
+func (this *History) GetLatestConverted() *msg.StateMessageOut {
+	this.RLock()
+	defer this.RUnlock()
+	if len(this.messagesConverted) == 0 {
+		return nil
+	}
+	return this.messagesConverted[len(this.messagesConverted)-1]
+}
+
 func (this *History) GetLatest() *msg.StateMessage {
 	this.RLock()
 	defer this.RUnlock()
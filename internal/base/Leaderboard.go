@@ -0,0 +1,188 @@
+package base
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	sync "github.com/sasha-s/go-deadlock"
+	msg "github.com/Project-Wartemis/pw-backend/internal/message"
+)
+
+const (
+	RATING_INITIAL = 1500.0
+	RATING_K       = 32.0
+)
+
+type Rating struct {
+	Name string      `json:"name"`
+	Rating float64   `json:"rating"`
+	Games int        `json:"games"`
+	Wins int         `json:"wins"`
+}
+
+// elo ratings of bots by name, separate for every game
+// games with more than two players count as a match between every pair of players
+type Leaderboard struct {
+	sync.RWMutex
+	ratings map[string]map[string]*Rating
+}
+
+func NewLeaderboard() *Leaderboard {
+	return &Leaderboard {
+		ratings: map[string]map[string]*Rating{},
+	}
+}
+
+// the ratings of a game, best first
+func (this *Leaderboard) GetRatings(game string) []*Rating {
+	this.RLock()
+	defer this.RUnlock()
+	result := []*Rating{}
+	for _,rating := range this.ratings[game] {
+		clone := *rating
+		result = append(result, &clone)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Rating != result[j].Rating {
+			return result[i].Rating > result[j].Rating
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func (this *Leaderboard) GetGames() []string {
+	this.RLock()
+	defer this.RUnlock()
+	result := []string{}
+	for game := range this.ratings {
+		result = append(result, game)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (this *Leaderboard) SetRating(game string, rating *Rating) {
+	this.Lock()
+	defer this.Unlock()
+	if this.ratings[game] == nil {
+		this.ratings[game] = map[string]*Rating{}
+	}
+	this.ratings[game][rating.Name] = rating
+}
+
+// has to be called with the lock held
+func (this *Leaderboard) getOrCreate(game string, name string) *Rating {
+	if this.ratings[game] == nil {
+		this.ratings[game] = map[string]*Rating{}
+	}
+	rating := this.ratings[game][name]
+	if rating == nil {
+		rating = &Rating {
+			Name: name,
+			Rating: RATING_INITIAL,
+		}
+		this.ratings[game][name] = rating
+	}
+	return rating
+}
+
+// updates the ratings with the result of a game, names maps the player ids to the names of their bots
+// returns copies of the updated ratings
+func (this *Leaderboard) HandleResult(game string, names map[int]string, result *msg.GameResult) []*Rating {
+	ids := []int{}
+	for id := range names {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	if len(ids) < 2 {
+		return []*Rating{}
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	deltas := map[string]float64{}
+	k := RATING_K / float64(len(ids) - 1)
+	for _,a := range ids {
+		for _,b := range ids {
+			if a == b || names[a] == names[b] {
+				continue // a bot playing itself says nothing about its strength
+			}
+			ratingA := this.getOrCreate(game, names[a]).Rating
+			ratingB := this.getOrCreate(game, names[b]).Rating
+			expected := 1 / (1 + math.Pow(10, (ratingB - ratingA) / 400))
+			deltas[names[a]] += k * (compareResult(result, a, b) - expected)
+		}
+	}
+
+	winners := map[int]bool{}
+	for _,id := range result.Winners {
+		winners[id] = true
+	}
+	updated := []*Rating{}
+	seen := map[string]bool{}
+	for _,id := range ids {
+		name := names[id]
+		rating := this.getOrCreate(game, name)
+		if winners[id] {
+			rating.Wins++
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		rating.Rating += deltas[name]
+		rating.Games++
+		clone := *rating
+		updated = append(updated, &clone)
+	}
+	return updated
+}
+
+// the score of player a against player b: 1 for a win, 0.5 for a draw and 0 for a loss
+// uses the ranks if there are any, otherwise the scores, otherwise the winners
+func compareResult(result *msg.GameResult, a int, b int) float64 {
+	compare := func(better bool, worse bool) float64 {
+		switch {
+			case better:
+				return 1
+			case worse:
+				return 0
+			default:
+				return 0.5
+		}
+	}
+	if len(result.Ranks) > 0 {
+		rankA, foundA := result.Ranks[a]
+		rankB, foundB := result.Ranks[b]
+		if !foundA {
+			rankA = math.MaxInt32
+		}
+		if !foundB {
+			rankB = math.MaxInt32
+		}
+		return compare(rankA < rankB, rankA > rankB) // rank 1 is the best
+	}
+	if len(result.Scores) > 0 {
+		return compare(result.Scores[a] > result.Scores[b], result.Scores[a] < result.Scores[b])
+	}
+	winnerA, winnerB := false, false
+	for _,id := range result.Winners {
+		winnerA = winnerA || id == a
+		winnerB = winnerB || id == b
+	}
+	return compare(winnerA && !winnerB, winnerB && !winnerA)
+}
+
+
+
+// lock for json marshalling
+
+func (this *Leaderboard) MarshalJSON() ([]byte, error) {
+	result := map[string][]*Rating{}
+	for _,game := range this.GetGames() {
+		result[game] = this.GetRatings(game)
+	}
+	return json.Marshal(result)
+}
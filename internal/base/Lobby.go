@@ -1,24 +1,141 @@
 package base
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"github.com/Project-Wartemis/pw-backend/internal/auth"
 	"github.com/Project-Wartemis/pw-backend/internal/message"
+	"github.com/Project-Wartemis/pw-backend/internal/storage"
+	"github.com/Project-Wartemis/pw-backend/internal/util"
+	"github.com/Project-Wartemis/pw-backend/internal/validation"
+)
+
+const (
+	COUNTER_CLIENT = "client"
+	COUNTER_GAME   = "game"
 )
 
 type Lobby struct {
 	Room
 	Games []*Game `json:"games"`
+	Compatibility map[int][]int `json:"compatibility"` // refreshed when marshalling, engine id to the ids of the bots that can play it
 	gamesById map[int]*Game
+	tournaments []*Tournament
+	tournamentsById map[int]*Tournament
+	validator *validation.Validator
+	authenticator *auth.Authenticator
+	matchmaker *Matchmaker
+	reaper *Reaper
+	leaderboard *Leaderboard
+	storage storage.Storage
 }
 
-func NewLobby() *Lobby {
+func NewLobby(store storage.Storage, authenticator *auth.Authenticator) *Lobby {
 	room := NewRoom("lobby")
-	return &Lobby {
+	lobby := &Lobby {
 		Room: *room,
 		Games: []*Game{},
 		gamesById: map[int]*Game{},
+		tournaments: []*Tournament{},
+		tournamentsById: map[int]*Tournament{},
+		validator: validation.NewValidator(),
+		authenticator: authenticator,
+		leaderboard: NewLeaderboard(),
+		storage: store,
+	}
+	lobby.matchmaker = NewMatchmaker(lobby)
+	lobby.reaper = NewReaper(lobby)
+	return lobby
+}
+
+// loads all games from storage, unfinished games are stopped since their engine is gone
+// archived games are not loaded, they are only read from storage when requested
+func (this *Lobby) LoadGames() error {
+	records, err := this.getStorage().LoadGames()
+	if err != nil {
+		return err
+	}
+
+	clients := map[int]*Client{}
+	archived := 0
+	for _,record := range records {
+		GAME_COUNTER.SetMinimum(record.Id)
+		if record.Archived {
+			archived++
+			continue
+		}
+		game := restoreGame(this, record, clients)
+		this.addGame(game) // it is already stored
+		if !game.GetStopped() {
+			log.Warnf("Game [%s] was not finished, marking it as stopped", game.GetName())
+			game.setStopped(true)
+			if game.GetStarted() {
+				game.setAborted("The server restarted while the game was running")
+			}
+			game.save() // otherwise it would be stopped again, with a new stop time, after every restart
+		}
+	}
+	for id := range clients {
+		CLIENT_COUNTER.SetMinimum(id)
+	}
+
+	err = this.loadCounter(COUNTER_GAME, &GAME_COUNTER)
+	if err != nil {
+		return err
+	}
+	err = this.loadCounter(COUNTER_CLIENT, &CLIENT_COUNTER)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Loaded [%d] games from storage, [%d] more are archived", len(records) - archived, archived)
+	return nil
+}
+
+// loads the tokens that were issued through the api
+func (this *Lobby) LoadTokens() error {
+	records, err := this.getStorage().LoadTokens()
+	if err != nil {
+		return err
+	}
+	for _,record := range records {
+		this.GetAuthenticator().AddToken(&auth.Token {
+			Token: record.Token,
+			ClientType: record.ClientType,
+			Name: record.Name,
+		})
 	}
+	log.Infof("Loaded [%d] tokens from storage", len(records))
+	return nil
+}
+
+func (this *Lobby) LoadRatings() error {
+	records, err := this.getStorage().LoadRatings()
+	if err != nil {
+		return err
+	}
+	for _,record := range records {
+		this.GetLeaderboard().SetRating(record.Game, &Rating {
+			Name: record.Name,
+			Rating: record.Rating,
+			Games: record.Games,
+			Wins: record.Wins,
+		})
+	}
+	log.Infof("Loaded [%d] ratings from storage", len(records))
+	return nil
+}
+
+func (this *Lobby) loadCounter(name string, counter *util.SafeCounter) error {
+	value, err := this.getStorage().LoadCounter(name)
+	if err != nil {
+		return err
+	}
+	counter.SetMinimum(value)
+	return nil
 }
 
 func (this *Lobby) HandleConnect(connection *Connection) {
@@ -29,6 +146,19 @@ func (this *Lobby) HandleConnect(connection *Connection) {
 }
 
 func (this *Lobby) HandleDisconnect(client *Client) {
+	this.GetMatchmaker().Remove(client)
+	if client.GetType() == TYPE_BOT {
+		for _,game := range this.GetGames() {
+			game.HandleDisconnect(client)
+		}
+	}
+	if client.GetType() == TYPE_ENGINE {
+		for _,game := range this.GetGames() {
+			if game.getEngine() == client {
+				game.HandleEngineDisconnect()
+			}
+		}
+	}
 	if client.GetType() == TYPE_VIEWER {
 		this.RemoveClient(client)
 		this.RLock()
@@ -39,6 +169,63 @@ func (this *Lobby) HandleDisconnect(client *Client) {
 	}
 }
 
+// the disconnected client that a registering client takes over with its resume token, or nil for a new client
+// without a resume token, the name cannot be one of a disconnected client that is still in an active game
+func (this *Lobby) FindResumedClient(resumeToken string, clientType string, name string) (*Client, error) {
+	if resumeToken != "" {
+		if clientType == TYPE_VIEWER {
+			// viewers are not kept after a disconnect, the new one only takes over the games the old one created
+			if !this.isCreatorToken(resumeToken) {
+				return nil, errors.New("Invalid resume token")
+			}
+			return nil, nil
+		}
+		client := this.FindClientByResumeToken(resumeToken)
+		if client == nil || client.GetType() != clientType || client.GetName() != name {
+			return nil, errors.New("Invalid resume token")
+		}
+		if client.IsConnected() {
+			return nil, errors.New(fmt.Sprintf("Client [%d] is still connected", client.GetId()))
+		}
+		return client, nil
+	}
+	if clientType == TYPE_VIEWER {
+		return nil, nil
+	}
+	for _,client := range this.GetClients() {
+		if client.GetType() != clientType || client.GetName() != name || client.IsConnected() {
+			continue
+		}
+		if this.IsInActiveGame(client) {
+			return nil, errors.New(fmt.Sprintf("Name [%s] is in use by a disconnected [%s], register with its resume token to take it over", name, clientType))
+		}
+	}
+	return nil, nil
+}
+
+func (this *Lobby) isCreatorToken(resumeToken string) bool {
+	for _,game := range this.GetGames() {
+		token := game.getCreatorToken()
+		if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(resumeToken)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// whether the client is the engine or a player of a game that did not stop yet
+func (this *Lobby) IsInActiveGame(client *Client) bool {
+	for _,game := range this.GetGames() {
+		if game.GetStopped() {
+			continue
+		}
+		if game.getEngine() == client || len(game.getPlayersByClient(client)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func (this *Lobby) HandleReconnect(new *Client, old *Client) {
 	log.Infof("Reconnecting [%s]", new.GetName())
 	old.Transfer(new)
@@ -68,13 +255,118 @@ func (this *Lobby) TriggerUpdated() {
 }
 
 
+// called by a game once it stopped
+func (this *Lobby) HandleGameStopped(game *Game) {
+	this.rateGame(game)
+	for _,tournament := range this.GetTournaments() {
+		if tournament.HandleGameStopped(game) {
+			return
+		}
+	}
+}
+
+
+// updates the leaderboard of the game the engine runs, if the engine reported a result
+func (this *Lobby) rateGame(game *Game) {
+	result := game.GetResult()
+	if result == nil || game.GetReplay() {
+		return
+	}
+	client := game.getEngine()
+	if client == nil {
+		log.Warnf("Game [%d] has no engine, its result is not rated", game.GetId())
+		return
+	}
+	names := map[int]string{}
+	for _,player := range game.GetPlayers() {
+		names[player.GetId()] = player.GetClient().GetName()
+	}
+
+	engine := client.GetPlayedGame()
+	leaderboard := this.GetLeaderboard()
+	for _,rating := range leaderboard.HandleResult(engine, names, result) {
+		err := this.getStorage().SaveRating(&storage.RatingRecord {
+			Game: engine,
+			Name: rating.Name,
+			Rating: rating.Rating,
+			Games: rating.Games,
+			Wins: rating.Wins,
+		})
+		if err != nil {
+			log.Errorf("Could not save rating of [%s] for [%s] : [%s]", rating.Name, engine, err)
+		}
+	}
+	this.BroadcastToType(TYPE_VIEWER, message.NewLeaderboardMessage(engine, leaderboard.GetRatings(engine)))
+}
+
+
+
+// storage related stuff
+
+func (this *Lobby) SaveGame(game *Game) {
+	store := this.getStorage()
+	err := store.SaveGame(game.toRecord())
+	if err != nil {
+		log.Errorf("Could not save game [%d] : [%s]", game.GetId(), err)
+		return
+	}
+	err = store.SaveCounter(COUNTER_GAME, GAME_COUNTER.Get())
+	if err != nil {
+		log.Errorf("Could not save counter [%s] : [%s]", COUNTER_GAME, err)
+	}
+	err = store.SaveCounter(COUNTER_CLIENT, CLIENT_COUNTER.Get())
+	if err != nil {
+		log.Errorf("Could not save counter [%s] : [%s]", COUNTER_CLIENT, err)
+	}
+}
+
+func (this *Lobby) IssueToken(clientType string, name string) (*auth.Token, error) {
+	if clientType != TYPE_BOT && clientType != TYPE_ENGINE {
+		return nil, errors.New(fmt.Sprintf("Tokens can only be issued for a [%s] or an [%s], not for a [%s]", TYPE_BOT, TYPE_ENGINE, clientType))
+	}
+	if name == "" {
+		return nil, errors.New("A token needs a name")
+	}
+	token := this.GetAuthenticator().Issue(clientType, name)
+	err := this.getStorage().SaveToken(&storage.TokenRecord {
+		Token: token.Token,
+		ClientType: token.ClientType,
+		Name: token.Name,
+	})
+	if err != nil {
+		log.Errorf("Could not save token for [%s] [%s] : [%s]", clientType, name, err)
+	}
+	log.Infof("Issued a token for [%s] [%s]", clientType, name)
+	return token, nil
+}
+
+func (this *Lobby) SaveTurn(game *Game, state *message.StateMessage, converted *message.StateMessageOut) {
+	turn := &storage.TurnRecord {
+		Turn: converted.Turn,
+		Message: state,
+		Converted: converted,
+	}
+	err := this.getStorage().SaveTurn(game.GetId(), turn)
+	if err != nil {
+		log.Errorf("Could not save turn [%d] of game [%d] : [%s]", converted.Turn, game.GetId(), err)
+	}
+}
+
+
 
 // getters and setters
 
 func (this *Lobby) AddGame(game *Game) {
+	this.addGame(game)
+	game.save()
+}
+
+// adds the game without saving it
+func (this *Lobby) addGame(game *Game) {
 	log.Infof("Adding game [%s]", game.GetName())
 
 	this.setGameById(game.GetId(), game)
+	game.setLobby(this)
 
 	this.Lock()
 	defer this.Unlock()
@@ -101,6 +393,88 @@ func (this *Lobby) RemoveGame(game *Game) {
 	go this.TriggerUpdated()
 }
 
+// removes a stopped game from the lobby, it stays in storage
+func (this *Lobby) ArchiveGame(game *Game) {
+	log.Infof("Archiving game [%s]", game.GetName())
+	game.setArchived(true)
+	game.save()
+	this.RemoveGame(game)
+}
+
+// loads a game that is no longer in the lobby from storage, nil when it cannot be found
+// the game is not added to the lobby again, it can only be read
+func (this *Lobby) GetArchivedGame(id int) *Game {
+	record, err := this.getStorage().LoadGame(id)
+	if err != nil {
+		log.Errorf("Could not load game [%d] from storage : [%s]", id, err)
+		return nil
+	}
+	if record == nil || !record.Archived {
+		return nil
+	}
+	return restoreGame(this, record, map[int]*Client{})
+}
+
+// starts the tournament right away
+func (this *Lobby) AddTournament(tournament *Tournament) {
+	log.Infof("Adding tournament [%s]", tournament.GetName())
+
+	tournament.setLobby(this)
+	this.Lock()
+	this.tournaments = append(this.tournaments, tournament)
+	this.tournamentsById[tournament.GetId()] = tournament
+	this.Unlock()
+
+	tournament.Start()
+}
+
+func (this *Lobby) GetTournaments() []*Tournament {
+	this.RLock()
+	defer this.RUnlock()
+	result := make([]*Tournament, len(this.tournaments))
+	copy(result, this.tournaments)
+	return result
+}
+
+func (this *Lobby) GetTournamentById(id int) *Tournament {
+	this.RLock()
+	defer this.RUnlock()
+	return this.tournamentsById[id]
+}
+
+func (this *Lobby) GetGames() []*Game {
+	this.RLock()
+	defer this.RUnlock()
+	result := make([]*Game, len(this.Games))
+	copy(result, this.Games)
+	return result
+}
+
+// maps every engine to the bots that can be invited to its games
+func (this *Lobby) GetCompatibility() map[int][]int {
+	result := map[int][]int{}
+	clients := this.GetClients()
+	for _,engine := range clients {
+		if engine.GetType() != TYPE_ENGINE {
+			continue
+		}
+		bots := []int{}
+		for _,bot := range clients {
+			if bot.GetType() == TYPE_BOT && bot.CanPlay(engine) == nil {
+				bots = append(bots, bot.GetId())
+			}
+		}
+		result[engine.GetId()] = bots
+	}
+	return result
+}
+
+func (this *Lobby) setCompatibility(compatibility map[int][]int) {
+	this.Lock()
+	defer this.Unlock()
+	this.Compatibility = compatibility
+}
+
 func (this *Lobby) GetGameById(id int) *Game {
 	this.RLock()
 	defer this.RUnlock()
@@ -119,6 +493,42 @@ func (this *Lobby) removeGameById(id int) {
 	delete(this.gamesById, id)
 }
 
+func (this *Lobby) getStorage() storage.Storage {
+	this.RLock()
+	defer this.RUnlock()
+	return this.storage
+}
+
+func (this *Lobby) GetValidator() *validation.Validator {
+	this.RLock()
+	defer this.RUnlock()
+	return this.validator
+}
+
+func (this *Lobby) GetLeaderboard() *Leaderboard {
+	this.RLock()
+	defer this.RUnlock()
+	return this.leaderboard
+}
+
+func (this *Lobby) GetReaper() *Reaper {
+	this.RLock()
+	defer this.RUnlock()
+	return this.reaper
+}
+
+func (this *Lobby) GetMatchmaker() *Matchmaker {
+	this.RLock()
+	defer this.RUnlock()
+	return this.matchmaker
+}
+
+func (this *Lobby) GetAuthenticator() *auth.Authenticator {
+	this.RLock()
+	defer this.RUnlock()
+	return this.authenticator
+}
+
 
 
 // lock for json marshalling
@@ -126,6 +536,7 @@ func (this *Lobby) removeGameById(id int) {
 type JLobby Lobby
 
 func (this *Lobby) MarshalJSON() ([]byte, error) {
+    this.setCompatibility(this.GetCompatibility())
     this.RLock()
     defer this.RUnlock()
     return json.Marshal(JLobby(*this))
@@ -0,0 +1,203 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	sync "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+	msg "github.com/Project-Wartemis/pw-backend/internal/message"
+)
+
+// creates and starts games for bots that queued for a game
+// only engines that registered with a number of players are used
+type Matchmaker struct {
+	sync.RWMutex
+	lobby *Lobby
+	queues map[string][]*Client // the bots waiting for a game, in order of arrival
+	trigger chan bool
+}
+
+func NewMatchmaker(lobby *Lobby) *Matchmaker {
+	return &Matchmaker {
+		lobby: lobby,
+		queues: map[string][]*Client{},
+		trigger: make(chan bool, 1),
+	}
+}
+
+func (this *Matchmaker) Start() {
+	go this.run()
+}
+
+func (this *Matchmaker) run() {
+	for range this.trigger {
+		this.match()
+	}
+}
+
+// asks the matchmaker to look for matches, does not block
+func (this *Matchmaker) Trigger() {
+	select {
+		case this.trigger <- true:
+		default: // a run is already pending
+	}
+}
+
+func (this *Matchmaker) Enqueue(bot *Client, game string) error {
+	this.Lock()
+	defer this.Unlock()
+	for _,queue := range this.queues {
+		for _,queued := range queue {
+			if queued == bot {
+				return errors.New(fmt.Sprintf("Bot [%s] is already queued", bot.GetName()))
+			}
+		}
+	}
+	this.queues[game] = append(this.queues[game], bot)
+	log.Infof("Bot [%s] queued for game [%s]", bot.GetName(), game)
+	return nil
+}
+
+func (this *Matchmaker) Remove(client *Client) {
+	this.Lock()
+	defer this.Unlock()
+	for game,queue := range this.queues {
+		for i,queued := range queue {
+			if queued == client {
+				this.queues[game] = append(queue[:i], queue[i+1:]...)
+				log.Infof("Bot [%s] removed from the queue for game [%s]", client.GetName(), game)
+				return
+			}
+		}
+	}
+}
+
+func (this *Matchmaker) getQueuedGames() []string {
+	this.RLock()
+	defer this.RUnlock()
+	result := []string{}
+	for game,queue := range this.queues {
+		if len(queue) > 0 {
+			result = append(result, game)
+		}
+	}
+	return result
+}
+
+// removes the first bots from the queue, or returns nil if not enough bots are waiting
+func (this *Matchmaker) take(game string, count int) []*Client {
+	this.Lock()
+	defer this.Unlock()
+	queue := this.queues[game]
+	if len(queue) < count {
+		return nil
+	}
+	result := make([]*Client, count)
+	copy(result, queue)
+	this.queues[game] = queue[count:]
+	return result
+}
+
+// puts bots back at the front of the queue, in the same order, bots that disconnected in the meantime are left out
+func (this *Matchmaker) requeue(game string, bots []*Client) {
+	connected := []*Client{}
+	for _,bot := range bots {
+		if bot.IsConnected() {
+			connected = append(connected, bot)
+		}
+	}
+	this.Lock()
+	defer this.Unlock()
+	this.queues[game] = append(connected, this.queues[game]...)
+}
+
+
+
+// matching
+
+func (this *Matchmaker) match() {
+	for _,game := range this.getQueuedGames() {
+		for {
+			engine := this.findEngine(game)
+			if engine == nil {
+				break
+			}
+			bots := this.take(game, engine.GetPlayerCount())
+			if bots == nil {
+				break
+			}
+			this.createGame(game, engine, bots)
+		}
+	}
+}
+
+// the connected engine for the game that runs the least games
+func (this *Matchmaker) findEngine(game string) *Client {
+	running := map[*Client]int{}
+	for _,g := range this.getLobby().GetGames() {
+		if g.GetStarted() && !g.GetStopped() {
+			running[g.getEngine()]++
+		}
+	}
+
+	var result *Client
+	for _,client := range this.getLobby().GetClients() {
+		if client.GetType() != TYPE_ENGINE || !client.IsConnected() || client.GetPlayerCount() == 0 {
+			continue
+		}
+		if client.GetPlayedGame() != game {
+			continue
+		}
+		if result == nil || running[client] < running[result] {
+			result = client
+		}
+	}
+	return result
+}
+
+// bots that cannot be added to the game are removed from the queue, the others are queued again
+func (this *Matchmaker) createGame(played string, engine *Client, bots []*Client) {
+	names := []string{}
+	for _,bot := range bots {
+		names = append(names, bot.GetName())
+	}
+	game := NewGame(strings.Join(names, " vs "), engine)
+	added := []*Client{}
+	for _,bot := range bots {
+		err := game.AddPlayer(bot)
+		if err != nil {
+			log.Errorf("Matchmaker could not add bot [%s] to a game of [%s] : [%s]", bot.GetName(), engine.GetName(), err)
+			bot.SendError(fmt.Sprintf("Could not create a match, you are no longer queued: [%s]", err))
+			continue
+		}
+		added = append(added, bot)
+	}
+	if len(added) < len(bots) {
+		this.requeue(played, added)
+		return
+	}
+	this.getLobby().AddGame(game)
+
+	for _,bot := range bots {
+		bot.SendMessage(msg.NewMatchedMessage(game.GetId()))
+	}
+
+	err := game.Start()
+	if err != nil {
+		log.Errorf("Matchmaker could not start game [%d] : [%s]", game.GetId(), err)
+		return
+	}
+	log.Infof("Matchmaker started game [%d] : [%s]", game.GetId(), game.GetName())
+	this.getLobby().TriggerUpdated()
+}
+
+
+
+// getters and setters
+
+func (this *Matchmaker) getLobby() *Lobby {
+	this.RLock()
+	defer this.RUnlock()
+	return this.lobby
+}
@@ -2,15 +2,23 @@ package base
 
 import (
 	"encoding/json"
+	"time"
 	"github.com/google/uuid"
 	sync "github.com/sasha-s/go-deadlock"
+	"github.com/Project-Wartemis/pw-backend/internal/storage"
 )
 
 type Player struct {
 	sync.RWMutex
 	Id int `json:"id"`
 	Client *Client `json:"client"`
+	Eliminated bool `json:"eliminated"` // forfeited, and no longer asked to move
 	key string
+	deadline *time.Timer
+	deadlineTurn int // the turn we are waiting on an action for, -1 if none
+	rewriter *stateRewriter
+	disconnected bool
+	reconnectTimer *time.Timer // forfeits the player when the bot does not reconnect in time
 }
 
 func NewPlayer(id int, client *Client) *Player {
@@ -18,9 +26,121 @@ func NewPlayer(id int, client *Client) *Player {
 		Id: id,
 		Client: client,
 		key: uuid.New().String(),
+		deadlineTurn: -1,
+		rewriter: newStateRewriter(id),
 	}
 }
 
+func (this *Player) toRecord() *storage.PlayerRecord {
+	return &storage.PlayerRecord {
+		Id: this.GetId(),
+		Client: this.GetClient().toRecord(),
+		Eliminated: this.GetEliminated(),
+	}
+}
+
+
+
+// move deadlines
+
+// calls onExpire if the deadline is not cleared in time
+// a deadline that is already running for the same turn is kept
+func (this *Player) StartDeadline(turn int, timeout time.Duration, onExpire func()) {
+	this.Lock()
+	defer this.Unlock()
+	if this.deadlineTurn == turn {
+		return
+	}
+	if this.deadline != nil {
+		this.deadline.Stop()
+	}
+	this.deadlineTurn = turn
+	this.deadline = time.AfterFunc(timeout, func() {
+		if this.expireDeadline(turn) {
+			onExpire()
+		}
+	})
+}
+
+func (this *Player) ClearDeadline() {
+	this.Lock()
+	defer this.Unlock()
+	if this.deadline != nil {
+		this.deadline.Stop()
+	}
+	this.deadline = nil
+	this.deadlineTurn = -1
+}
+
+// returns false if the deadline was cleared or replaced in the meantime
+func (this *Player) expireDeadline(turn int) bool {
+	this.Lock()
+	defer this.Unlock()
+	if this.deadlineTurn != turn {
+		return false
+	}
+	this.deadline = nil
+	this.deadlineTurn = -1
+	return true
+}
+
+
+// reconnects
+
+// calls onExpire if the bot does not reconnect in time, a timeout of 0 waits forever
+func (this *Player) HandleDisconnect(timeout time.Duration, onExpire func()) {
+	this.Lock()
+	defer this.Unlock()
+	this.disconnected = true
+	this.stopReconnectTimer()
+	if timeout <= 0 {
+		return
+	}
+	this.reconnectTimer = time.AfterFunc(timeout, func() {
+		if this.expireReconnect() {
+			onExpire()
+		}
+	})
+}
+
+// returns false if the player was not waiting for a reconnect
+func (this *Player) HandleReconnect() bool {
+	this.Lock()
+	defer this.Unlock()
+	if !this.disconnected {
+		return false
+	}
+	this.disconnected = false
+	this.stopReconnectTimer()
+	return true
+}
+
+func (this *Player) ClearReconnectTimer() {
+	this.Lock()
+	defer this.Unlock()
+	this.stopReconnectTimer()
+}
+
+// has to be called with the lock held
+func (this *Player) stopReconnectTimer() {
+	if this.reconnectTimer != nil {
+		this.reconnectTimer.Stop()
+	}
+	this.reconnectTimer = nil
+}
+
+// returns false if the player reconnected in the meantime
+func (this *Player) expireReconnect() bool {
+	this.Lock()
+	defer this.Unlock()
+	if !this.disconnected {
+		return false
+	}
+	this.disconnected = false
+	this.reconnectTimer = nil
+	return true
+}
+
 
 
 // getters and setters
@@ -37,6 +157,24 @@ func (this *Player) GetClient() *Client {
 	return this.Client
 }
 
+func (this *Player) GetEliminated() bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.Eliminated
+}
+
+func (this *Player) setEliminated(eliminated bool) {
+	this.Lock()
+	defer this.Unlock()
+	this.Eliminated = eliminated
+}
+
+func (this *Player) getRewriter() *stateRewriter {
+	this.RLock()
+	defer this.RUnlock()
+	return this.rewriter
+}
+
 func (this *Player) GetKey() string {
 	this.RLock()
 	defer this.RUnlock()
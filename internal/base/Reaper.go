@@ -0,0 +1,92 @@
+package base
+
+import (
+	"expvar"
+	"time"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	CLIENT_TTL = time.Hour          // disconnected clients that are not in an active game are removed after this, 0 keeps them
+	GAME_RETENTION = 24 * time.Hour // stopped games are archived after this, 0 keeps them
+	REAPER_INTERVAL = time.Minute
+	REAPER_METRICS = expvar.NewMap("reaper") // published on /debug/vars
+)
+
+// removes stale clients from the lobby and archives stopped games, so a long running server does not keep growing
+type Reaper struct {
+	lobby *Lobby
+}
+
+func NewReaper(lobby *Lobby) *Reaper {
+	return &Reaper {
+		lobby: lobby,
+	}
+}
+
+func (this *Reaper) Start() {
+	if REAPER_INTERVAL <= 0 {
+		log.Warn("Reaper disabled, disconnected clients and stopped games are kept forever")
+		return
+	}
+	go this.run()
+}
+
+func (this *Reaper) run() {
+	ticker := time.NewTicker(REAPER_INTERVAL)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		this.Reap(now)
+	}
+}
+
+// returns the number of removed clients and archived games
+func (this *Reaper) Reap(now time.Time) (int, int) {
+	clients := this.reapClients(now)
+	games := this.archiveGames(now)
+	REAPER_METRICS.Add("runs", 1)
+	if clients > 0 || games > 0 {
+		log.Infof("Reaper removed [%d] clients and archived [%d] games", clients, games)
+		this.lobby.TriggerUpdated()
+	}
+	return clients, games
+}
+
+func (this *Reaper) reapClients(now time.Time) int {
+	if CLIENT_TTL <= 0 {
+		return 0
+	}
+	count := 0
+	for _,client := range this.lobby.GetClients() {
+		disconnectedAt := client.GetDisconnectedAt()
+		if client.IsConnected() || disconnectedAt.IsZero() || now.Sub(disconnectedAt) < CLIENT_TTL {
+			continue
+		}
+		if this.lobby.IsInActiveGame(client) {
+			continue // it can still reconnect and play on
+		}
+		this.lobby.RemoveClient(client)
+		count++
+	}
+	REAPER_METRICS.Add("clientsRemoved", int64(count))
+	return count
+}
+
+func (this *Reaper) archiveGames(now time.Time) int {
+	if GAME_RETENTION <= 0 {
+		return 0
+	}
+	count := 0
+	turns := 0
+	for _,game := range this.lobby.GetGames() {
+		if !game.GetStopped() || now.Sub(game.GetStoppedAt()) < GAME_RETENTION {
+			continue
+		}
+		turns += len(game.GetHistory().GetAllConverted())
+		this.lobby.ArchiveGame(game)
+		count++
+	}
+	REAPER_METRICS.Add("gamesArchived", int64(count))
+	REAPER_METRICS.Add("turnsArchived", int64(turns))
+	return count
+}
@@ -0,0 +1,128 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	msg "github.com/Project-Wartemis/pw-backend/internal/message"
+	"github.com/Project-Wartemis/pw-backend/internal/storage"
+)
+
+const (
+	REPLAY_VERSION = 1
+)
+
+// a finished game as it was shown to viewers, see the README for the format
+type Replay struct {
+	Version int                     `json:"version"`
+	Game *ReplayGame                `json:"game"`
+	Players []*storage.PlayerRecord `json:"players"`
+	Turns []*msg.StateMessageOut    `json:"turns"`
+	Result *msg.GameResult          `json:"result"`
+}
+
+type ReplayGame struct {
+	Id int                 `json:"id"`
+	Name string            `json:"name"`
+	Engine *storage.ClientRecord `json:"engine"`
+}
+
+func (this *Game) ToReplay() (*Replay, error) {
+	if !this.GetStopped() {
+		return nil, errors.New(fmt.Sprintf("Game [%d] has not finished yet", this.GetId()))
+	}
+	record := this.toRecord()
+	turns := []*msg.StateMessageOut{}
+	for _,turn := range this.GetHistory().GetAllConverted() {
+		if turn != nil { // engines do not have to start at turn 0 or send every turn
+			turns = append(turns, turn)
+		}
+	}
+	return &Replay {
+		Version: REPLAY_VERSION,
+		Game: &ReplayGame {
+			Id: record.Id,
+			Name: record.Name,
+			Engine: record.Engine,
+		},
+		Players: record.Players,
+		Turns: turns,
+		Result: record.Result,
+	}, nil
+}
+
+// adds the replay to the lobby as a new game that can only be viewed
+func (this *Lobby) ImportReplay(replay *Replay) (*Game, error) {
+	if replay.Version != REPLAY_VERSION {
+		return nil, errors.New(fmt.Sprintf("Unsupported replay version [%d], expected [%d]", replay.Version, REPLAY_VERSION))
+	}
+	if replay.Game == nil || replay.Game.Engine == nil {
+		return nil, errors.New("Replay has no game or engine")
+	}
+	id := GAME_COUNTER.GetNext()
+	turns := []*storage.TurnRecord{}
+	seen := map[int]bool{}
+	for _,turn := range replay.Turns {
+		if turn == nil {
+			continue
+		}
+		if turn.Turn < 0 || seen[turn.Turn] {
+			return nil, errors.New(fmt.Sprintf("Replay has an invalid or duplicate turn [%d]", turn.Turn))
+		}
+		seen[turn.Turn] = true
+		turn.Game = id
+		turns = append(turns, &storage.TurnRecord {
+			Turn: turn.Turn,
+			Message: nil, // the original states of the engine are not part of a replay
+			Converted: turn,
+		})
+	}
+	// the history is keyed by turn, so it has to be filled in order
+	sort.Slice(turns, func(i, j int) bool {
+		return turns[i].Turn < turns[j].Turn
+	})
+	// the clients get new ids, their ids on the other server can belong to anyone here
+	ids := map[int]int{}
+	localClient := func(client *storage.ClientRecord) *storage.ClientRecord {
+		local, found := ids[client.Id]
+		if !found {
+			local = CLIENT_COUNTER.GetNext()
+			ids[client.Id] = local
+		}
+		return &storage.ClientRecord {
+			Id: local,
+			Type: client.Type,
+			Name: client.Name,
+			Game: client.Game,
+		}
+	}
+	players := []*storage.PlayerRecord{}
+	for _,player := range replay.Players {
+		if player == nil || player.Client == nil {
+			return nil, errors.New("Replay has a player without a client")
+		}
+		players = append(players, &storage.PlayerRecord {
+			Id: player.Id,
+			Client: localClient(player.Client),
+			Eliminated: player.Eliminated,
+		})
+	}
+
+	record := &storage.GameRecord {
+		Id: id,
+		Name: replay.Game.Name,
+		Engine: localClient(replay.Game.Engine),
+		Players: players,
+		Started: true,
+		Stopped: true,
+		Replay: true,
+		Result: replay.Result,
+		Turns: turns,
+	}
+	game := restoreGame(this, record, map[int]*Client{})
+	this.AddGame(game)
+	for _,turn := range turns {
+		this.SaveTurn(game, turn.Message, turn.Converted)
+	}
+	return game, nil
+}
@@ -0,0 +1,134 @@
+package base
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"github.com/Project-Wartemis/pw-backend/internal/auth"
+	msg "github.com/Project-Wartemis/pw-backend/internal/message"
+	"github.com/Project-Wartemis/pw-backend/internal/storage"
+	"github.com/Project-Wartemis/pw-backend/internal/validation"
+)
+
+func makeReplayLobby(t *testing.T) *Lobby {
+	directory, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(directory) })
+	store, err := storage.NewBoltStorage(filepath.Join(directory, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	validation.SCHEMA_DIRECTORY = "../validation/resources/messages" // tests run in the directory of their package
+	return NewLobby(store, auth.NewAuthenticator(""))
+}
+
+// a stopped game whose engine started at turn 1 and skipped turn 3
+func makeReplayGame(lobby *Lobby) *Game {
+	engine := newRestoredClient(lobby, &storage.ClientRecord { Id: 101, Type: "engine", Name: "engine", Game: "test" })
+	game := NewGame("replay", engine)
+	game.Players = append(game.Players, NewPlayer(1, newRestoredClient(lobby, &storage.ClientRecord { Id: 102, Type: "bot", Name: "bot a" })))
+	game.Players = append(game.Players, NewPlayer(2, newRestoredClient(lobby, &storage.ClientRecord { Id: 103, Type: "bot", Name: "bot b" })))
+	for _,turn := range []int{1, 2, 4} {
+		game.GetHistory().AddConverted(&msg.StateMessageOut {
+			Game: game.Id,
+			Turn: turn,
+			State: json.RawMessage(`{"turn":` + strconv.Itoa(turn) + `}`),
+		})
+	}
+	game.Started = true
+	game.Stopped = true
+	game.Result = &msg.GameResult { Winners: []int{1} }
+	return game
+}
+
+func TestReplayRoundTrip(t *testing.T) {
+	lobby := makeReplayLobby(t)
+	original := makeReplayGame(lobby)
+
+	replay, err := original.ToReplay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _,turn := range replay.Turns {
+		if turn == nil {
+			t.Fatal("exported replay contains an empty turn")
+		}
+	}
+	// as if it was downloaded and uploaded again
+	raw, err := json.Marshal(replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Replay{}
+	err = json.Unmarshal(raw, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := lobby.ImportReplay(decoded)
+	if err != nil {
+		t.Fatalf("could not import [%s] : [%s]", raw, err)
+	}
+	if !imported.Replay || !imported.GetStopped() {
+		t.Error("imported game is not a stopped replay")
+	}
+	if imported.GetId() == original.GetId() {
+		t.Errorf("imported game reuses the id [%d]", original.GetId())
+	}
+	if len(imported.Players) != len(original.Players) {
+		t.Fatalf("imported game has [%d] players, expected [%d]", len(imported.Players), len(original.Players))
+	}
+	ids := map[int]bool{ original.Engine.GetId(): true }
+	for _,player := range original.Players {
+		ids[player.Client.GetId()] = true
+	}
+	clients := []*Client{ imported.Engine }
+	for i,player := range imported.Players {
+		if player.Client.GetName() != original.Players[i].Client.GetName() {
+			t.Errorf("player [%d] is [%s], expected [%s]", player.Id, player.Client.GetName(), original.Players[i].Client.GetName())
+		}
+		clients = append(clients, player.Client)
+	}
+	for _,client := range clients {
+		if ids[client.GetId()] {
+			t.Errorf("imported client [%s] reuses the id [%d]", client.GetName(), client.GetId())
+		}
+		ids[client.GetId()] = true
+	}
+	expected := original.GetHistory().GetAllConverted()
+	actual := imported.GetHistory().GetAllConverted()
+	if len(actual) != len(expected) {
+		t.Fatalf("imported history has [%d] turns, expected [%d]", len(actual), len(expected))
+	}
+	for i := range expected {
+		if (expected[i] == nil) != (actual[i] == nil) {
+			t.Errorf("turn [%d] is [%v], expected [%v]", i, actual[i], expected[i])
+			continue
+		}
+		if expected[i] == nil {
+			continue
+		}
+		if actual[i].Game != imported.GetId() || actual[i].Turn != i || string(actual[i].State) != string(expected[i].State) {
+			t.Errorf("turn [%d] is [%+v], expected [%+v]", i, actual[i], expected[i])
+		}
+	}
+}
+
+func TestImportReplayRejectsDuplicateTurns(t *testing.T) {
+	lobby := makeReplayLobby(t)
+	replay, err := makeReplayGame(lobby).ToReplay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.Turns = append(replay.Turns, &msg.StateMessageOut { Turn: 2 })
+	_, err = lobby.ImportReplay(replay)
+	if err == nil {
+		t.Error("expected an error for a duplicate turn")
+	}
+}
@@ -55,6 +55,14 @@ func (this *Room) GetName() string {
 	return this.Name
 }
 
+func (this *Room) GetClients() []*Client {
+	this.RLock()
+	defer this.RUnlock()
+	result := make([]*Client, len(this.Clients))
+	copy(result, this.Clients)
+	return result
+}
+
 func (this *Room) AddClient(client *Client) {
 	this.setClientById(client.GetId(), client)
 
@@ -66,6 +74,8 @@ func (this *Room) AddClient(client *Client) {
 func (this *Room) RemoveClient(client *Client) {
 	log.Infof("Removing client [%s] from room [%s]", client.GetName(), this.GetName())
 
+	this.removeClientById(client.GetId())
+
 	this.Lock()
 	defer this.Unlock()
 	for i,c := range this.Clients {
@@ -78,23 +88,13 @@ func (this *Room) RemoveClient(client *Client) {
 	}
 }
 
-func (this *Room) FindDuplicateUnconnectedClient(client *Client) *Client {
+func (this *Room) FindClientByResumeToken(resumeToken string) *Client {
 	this.RLock()
 	defer this.RUnlock()
 	for _,c := range this.Clients {
-		if c.GetType() != client.GetType() {
-			continue
-		}
-		if c.GetName() != client.GetName() {
-			continue
-		}
-		if c.GetGame() != client.GetGame() {
-			continue
-		}
-		if c.IsConnected() {
-			continue
+		if c.MatchesResumeToken(resumeToken) {
+			return c
 		}
-		return c
 	}
 	return nil
 }
@@ -0,0 +1,105 @@
+package base
+
+import (
+	"bytes"
+	"strconv"
+)
+
+var (
+	VIEWER_REWRITER = newStateRewriter(-1) // does not match any player, so every padded id becomes the player id
+)
+
+// replaces the padded player ids in a state with the ids of the players
+// the padded id of the player itself becomes 1
+// only complete strings are replaced, object keys stay strings
+type stateRewriter struct {
+	self int
+}
+
+func newStateRewriter(self int) *stateRewriter {
+	return &stateRewriter {
+		self: self,
+	}
+}
+
+// the state has to be valid json
+func (this *stateRewriter) Rewrite(state []byte) []byte {
+	prefix := []byte(PLAYER_PREFIX)
+	if !bytes.Contains(state, prefix) {
+		return state
+	}
+
+	result := make([]byte, 0, len(state))
+	for i := 0; i < len(state); {
+		if state[i] != '"' {
+			result = append(result, state[i])
+			i++
+			continue
+		}
+
+		// find the end of the string
+		end := i+1
+		for end < len(state) && state[end] != '"' {
+			if state[end] == '\\' {
+				end++ // skip the escaped character
+			}
+			end++
+		}
+		literal := state[i:end+1]
+		content := state[i+1:end]
+		i = end+1
+
+		id, ok := parsePaddedContent(content)
+		if !ok {
+			result = append(result, literal...)
+			continue
+		}
+		if id == this.self {
+			id = 1
+		}
+		if isKey(state, i) {
+			result = append(result, '"')
+			result = strconv.AppendInt(result, int64(id), 10)
+			result = append(result, '"')
+		} else {
+			result = strconv.AppendInt(result, int64(id), 10)
+		}
+	}
+	return result
+}
+
+// returns the id if the content of a string is exactly a padded player id
+func parsePaddedContent(content []byte) (int, bool) {
+	if len(content) <= len(PLAYER_PREFIX) + len(PLAYER_SUFFIX) {
+		return 0, false
+	}
+	if !bytes.HasPrefix(content, []byte(PLAYER_PREFIX)) || !bytes.HasSuffix(content, []byte(PLAYER_SUFFIX)) {
+		return 0, false
+	}
+	digits := content[len(PLAYER_PREFIX):len(content)-len(PLAYER_SUFFIX)]
+	for _,digit := range digits {
+		if digit < '0' || digit > '9' {
+			return 0, false
+		}
+	}
+	id, err := strconv.Atoi(string(digits))
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// a string is an object key if it is followed by a colon
+func isKey(state []byte, i int) bool {
+	for ; i < len(state); i++ {
+		switch state[i] {
+			case ' ', '\t', '\n', '\r':
+				continue
+			case ':':
+				return true
+			default:
+				return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	msg "github.com/Project-Wartemis/pw-backend/internal/message"
+)
+
+// a state where every player owns a few units and knows about every other player
+func makeBenchmarkState(players int) *msg.StateMessage {
+	ids := []string{}
+	units := []string{}
+	for i := 1; i <= players; i++ {
+		id := PLAYER_PREFIX + strconv.Itoa(i) + PLAYER_SUFFIX
+		ids = append(ids, id)
+		for j := 0; j < 10; j++ {
+			units = append(units, fmt.Sprintf(`{"owner":"%s","x":%d,"y":%d,"strength":%d}`, id, i, j, i*j))
+		}
+	}
+	state := fmt.Sprintf(`{"players":["%s"],"units":[%s]}`, strings.Join(ids, `","`), strings.Join(units, ","))
+	return &msg.StateMessage {
+		Game: 1,
+		Turn: 1,
+		Players: ids,
+		State: json.RawMessage(state),
+	}
+}
+
+func makeBenchmarkGame(players int) *Game {
+	game := NewGame("benchmark", nil)
+	for i := 1; i <= players; i++ {
+		game.Players = append(game.Players, NewPlayer(i, nil))
+	}
+	return game
+}
+
+func benchmarkFanOut(b *testing.B, players int) {
+	game := makeBenchmarkGame(players)
+	message := makeBenchmarkState(players)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _,player := range game.Players {
+			game.makeStateConverter(player)(message)
+		}
+		game.makeStateConverter(nil)(message)
+	}
+}
+
+func BenchmarkFanOut2(b *testing.B)  { benchmarkFanOut(b, 2) }
+func BenchmarkFanOut8(b *testing.B)  { benchmarkFanOut(b, 8) }
+func BenchmarkFanOut32(b *testing.B) { benchmarkFanOut(b, 32) }
+
+func BenchmarkRewrite(b *testing.B) {
+	state := makeBenchmarkState(8).State
+	rewriter := newStateRewriter(1)
+	b.SetBytes(int64(len(state)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rewriter.Rewrite(state)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	// <n> is the padded id of player n
+	padded := strings.NewReplacer(
+		"<1>", PLAYER_PREFIX + "1" + PLAYER_SUFFIX,
+		"<2>", PLAYER_PREFIX + "2" + PLAYER_SUFFIX,
+		"<3>", PLAYER_PREFIX + "3" + PLAYER_SUFFIX,
+		"<12>", PLAYER_PREFIX + "12" + PLAYER_SUFFIX,
+		"<x>", PLAYER_PREFIX + "x" + PLAYER_SUFFIX,
+		"<>", PLAYER_PREFIX + PLAYER_SUFFIX,
+	)
+	tests := []struct {
+		name string
+		rewriter *stateRewriter
+		state string
+		expected string
+	}{
+		{"without padded ids", newStateRewriter(2), `{"a":[1,"b",null]}`, `{"a":[1,"b",null]}`},
+		{"value", newStateRewriter(2), `{"owner":"<3>"}`, `{"owner":3}`},
+		{"value of the receiving player", newStateRewriter(2), `{"owner":"<2>"}`, `{"owner":1}`},
+		{"multiple digits", newStateRewriter(2), `["<12>"]`, `[12]`},
+		{"array", newStateRewriter(3), `["<1>","<2>","<3>"]`, `[1,2,1]`},
+		{"viewer", VIEWER_REWRITER, `["<1>","<2>","<3>"]`, `[1,2,3]`},
+		{"key", newStateRewriter(2), `{"<3>":true}`, `{"3":true}`},
+		{"key of the receiving player", newStateRewriter(2), `{"<2>" : "<3>"}`, `{"1" : 3}`},
+		{"key followed by whitespace", newStateRewriter(2), "{\"<3>\"\n\t:1}", "{\"3\"\n\t:1}"},
+		{"value followed by whitespace", newStateRewriter(2), `{"a": "<3>" ,"b":"<3>"}`, `{"a": 3 ,"b":3}`},
+		{"inside a longer string", newStateRewriter(2), `["player <3>","<3> wins","<3><3>"]`, `["player <3>","<3> wins","<3><3>"]`},
+		{"inside a longer key", newStateRewriter(2), `{"units of <3>":1}`, `{"units of <3>":1}`},
+		{"not a number", newStateRewriter(2), `["<x>","<>"]`, `["<x>","<>"]`},
+		{"escaped quote before the marker", newStateRewriter(2), `["a\"<3>"]`, `["a\"<3>"]`},
+		{"escaped quotes around the marker", newStateRewriter(2), `["\"<3>\""]`, `["\"<3>\""]`},
+		{"escaped backslash before the marker", newStateRewriter(2), `["\\<3>"]`, `["\\<3>"]`},
+		{"string ending in a backslash before the marker", newStateRewriter(2), `["\\","<3>"]`, `["\\",3]`},
+		{"string ending in an escaped quote before the marker", newStateRewriter(2), `["\"","<3>"]`, `["\"",3]`},
+	}
+	for _,test := range tests {
+		state := padded.Replace(test.state)
+		expected := padded.Replace(test.expected)
+		actual := string(test.rewriter.Rewrite([]byte(state)))
+		if actual != expected {
+			t.Errorf("%s: rewriting [%s] gave [%s], expected [%s]", test.name, state, actual, expected)
+		}
+		if !json.Valid([]byte(actual)) {
+			t.Errorf("%s: rewriting [%s] gave invalid json [%s]", test.name, state, actual)
+		}
+	}
+}
@@ -0,0 +1,549 @@
+package base
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	sync "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+	msg "github.com/Project-Wartemis/pw-backend/internal/message"
+	"github.com/Project-Wartemis/pw-backend/internal/util"
+)
+
+const (
+	TOURNAMENT_ROUND_ROBIN = "round-robin"
+	TOURNAMENT_SWISS       = "swiss"
+	TOURNAMENT_KNOCKOUT    = "knockout"
+)
+
+var (
+	TOURNAMENT_FORMATS = []string{TOURNAMENT_ROUND_ROBIN, TOURNAMENT_SWISS, TOURNAMENT_KNOCKOUT}
+	TOURNAMENT_COUNTER util.SafeCounter
+)
+
+// a game between two bots, or a bye when there is only one bot
+type Match struct {
+	Round int  `json:"round"`
+	Bots []int `json:"bots"` // client ids
+	Game int   `json:"game"` // 0 until the match is scheduled
+	Done bool  `json:"done"`
+	Winner int `json:"winner"` // client id, 0 for a draw
+	Draw bool  `json:"draw"` // in a knockout the first bot advances after a draw
+}
+
+type Standing struct {
+	Bot int            `json:"bot"`
+	Name string        `json:"name"`
+	Played int         `json:"played"`
+	Wins int           `json:"wins"`
+	Draws int          `json:"draws"`
+	Losses int         `json:"losses"`
+	Byes int           `json:"byes"`
+	Points float64     `json:"points"` // 1 for a win or a bye, 0.5 for a draw
+	Eliminated bool    `json:"eliminated"` // knockout only
+}
+
+// schedules the games between a fixed set of bots, at most Concurrency at a time
+type Tournament struct {
+	sync.RWMutex
+	Id int                 `json:"id"`
+	Name string            `json:"name"`
+	Engine *Client         `json:"engine"`
+	Bots []*Client         `json:"bots"` // in order of seeding
+	Format string          `json:"format"`
+	Concurrency int        `json:"concurrency"`
+	Rounds int             `json:"rounds"` // swiss only
+	MoveTimeout int        `json:"moveTimeout"`
+	Round int              `json:"round"` // the current round, starting at 1
+	Stopped bool           `json:"stopped"`
+	Matches []*Match       `json:"matches"`
+	Standings []*Standing  `json:"standings"` // sorted by points
+	lobby *Lobby
+	scheduleLock sync.Mutex // results are handled and games are scheduled one at a time
+}
+
+func NewTournament(name string, engine *Client, bots []*Client, format string, concurrency int, rounds int) (*Tournament, error) {
+	if !util.Includes(TOURNAMENT_FORMATS, format) {
+		return nil, errors.New(fmt.Sprintf("Invalid format [%s]", format))
+	}
+	if engine.GetType() != TYPE_ENGINE {
+		return nil, errors.New(fmt.Sprintf("Client [%d] is not an engine", engine.GetId()))
+	}
+	if len(bots) < 2 {
+		return nil, errors.New("A tournament needs at least two bots")
+	}
+	if concurrency < 1 {
+		return nil, errors.New(fmt.Sprintf("Concurrency [%d] has to be at least 1", concurrency))
+	}
+
+	standings := []*Standing{}
+	seen := map[int]bool{}
+	for _,bot := range bots {
+		if bot.GetType() != TYPE_BOT {
+			return nil, errors.New(fmt.Sprintf("Client [%d] is not a bot", bot.GetId()))
+		}
+		if seen[bot.GetId()] {
+			return nil, errors.New(fmt.Sprintf("Bot [%d] was added twice", bot.GetId()))
+		}
+		seen[bot.GetId()] = true
+		err := bot.CanPlay(engine)
+		if err != nil {
+			return nil, err
+		}
+		standings = append(standings, &Standing {
+			Bot: bot.GetId(),
+			Name: bot.GetName(),
+		})
+	}
+
+	if format == TOURNAMENT_SWISS && rounds < 1 {
+		rounds = int(math.Ceil(math.Log2(float64(len(bots)))))
+	}
+	if format != TOURNAMENT_SWISS {
+		rounds = 0
+	}
+
+	return &Tournament {
+		Id: TOURNAMENT_COUNTER.GetNext(),
+		Name: name,
+		Engine: engine,
+		Bots: bots,
+		Format: format,
+		Concurrency: concurrency,
+		Rounds: rounds,
+		Round: 0,
+		Stopped: false,
+		Matches: []*Match{},
+		Standings: standings,
+	}, nil
+}
+
+func (this *Tournament) Start() {
+	this.scheduleLock.Lock()
+	defer this.scheduleLock.Unlock()
+	log.Infof("Starting tournament [%s]", this.GetName())
+	this.nextRound()
+	this.schedule()
+	this.publish()
+}
+
+// records the result of a game of this tournament, returns false if the game is not part of it
+func (this *Tournament) HandleGameStopped(game *Game) bool {
+	this.scheduleLock.Lock()
+	defer this.scheduleLock.Unlock()
+
+	match := this.getMatchByGame(game.GetId())
+	if match == nil {
+		return false
+	}
+
+	winners := []int{}
+	if result := game.GetResult(); result != nil {
+		for _,id := range result.Winners {
+			player := game.getPlayerById(id)
+			if player != nil {
+				winners = append(winners, player.GetClient().GetId())
+			}
+		}
+	}
+
+	this.Lock()
+	match.Done = true
+	if len(winners) == 1 {
+		match.Winner = winners[0]
+	} else {
+		match.Draw = true
+		if this.Format == TOURNAMENT_KNOCKOUT {
+			match.Winner = match.Bots[0]
+		}
+	}
+	this.recordMatch(match)
+	roundDone := this.isRoundDone()
+	this.Unlock()
+
+	log.Infof("Tournament [%s] finished game [%d], winner [%d]", this.GetName(), game.GetId(), match.Winner)
+	if roundDone {
+		this.nextRound()
+	}
+	this.schedule()
+	this.publish()
+	return true
+}
+
+// starts games for the matches of the current round, until the concurrency limit is reached
+func (this *Tournament) schedule() {
+	for {
+		match := this.getNextMatch()
+		if match == nil {
+			return
+		}
+
+		engine := this.getEngine()
+		game := NewGame(fmt.Sprintf("%s - round %d", this.GetName(), match.Round), engine)
+		game.SetMoveTimeout(this.GetMoveTimeout())
+		var err error
+		for _,id := range match.Bots {
+			bot := this.getLobby().GetClientById(id)
+			if bot == nil {
+				err = errors.New(fmt.Sprintf("Bot [%d] not found", id))
+				break
+			}
+			err = game.AddPlayer(bot)
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Errorf("Tournament [%s] could not create a game for bots [%v] : [%s]", this.GetName(), match.Bots, err)
+			this.Lock()
+			match.Done = true
+			match.Draw = true
+			if this.Format == TOURNAMENT_KNOCKOUT {
+				match.Winner = match.Bots[0]
+			}
+			this.recordMatch(match)
+			roundDone := this.isRoundDone()
+			this.Unlock()
+			if roundDone {
+				this.nextRound()
+			}
+			continue
+		}
+
+		this.Lock()
+		match.Game = game.GetId()
+		this.Unlock()
+		this.getLobby().AddGame(game)
+		err = game.Start()
+		if err != nil {
+			log.Errorf("Tournament [%s] could not start game [%d] : [%s]", this.GetName(), game.GetId(), err)
+		}
+	}
+}
+
+// the first unscheduled match of the current round, or nil if none or too many games are running
+func (this *Tournament) getNextMatch() *Match {
+	this.RLock()
+	defer this.RUnlock()
+	running := 0
+	var next *Match
+	for _,match := range this.Matches {
+		if match.Round != this.Round || match.Done {
+			continue
+		}
+		if match.Game != 0 {
+			running++
+		} else if next == nil {
+			next = match
+		}
+	}
+	if running >= this.Concurrency {
+		return nil
+	}
+	return next
+}
+
+func (this *Tournament) getMatchByGame(game int) *Match {
+	this.RLock()
+	defer this.RUnlock()
+	for _,match := range this.Matches {
+		if match.Game == game && !match.Done {
+			return match
+		}
+	}
+	return nil
+}
+
+func (this *Tournament) publish() {
+	lobby := this.getLobby()
+	lobby.BroadcastToType(TYPE_VIEWER, msg.NewTournamentMessage(this))
+	lobby.TriggerUpdated()
+}
+
+
+
+// rounds and pairings
+
+// pairs the bots for the next round, or stops the tournament when there are no more rounds
+// rounds without any game to play are skipped
+func (this *Tournament) nextRound() {
+	for {
+		this.Lock()
+		if this.Round > 0 && this.isFinished() {
+			this.Stopped = true
+			this.Unlock()
+			log.Infof("Tournament [%s] finished", this.Name)
+			return
+		}
+		this.Round++
+		pairings := this.makePairings()
+		for _,bots := range pairings {
+			match := &Match {
+				Round: this.Round,
+				Bots: bots,
+			}
+			if len(bots) == 1 {
+				match.Done = true
+				match.Winner = bots[0]
+				this.recordMatch(match)
+			}
+			this.Matches = append(this.Matches, match)
+		}
+		roundDone := this.isRoundDone()
+		this.Unlock()
+		if !roundDone {
+			return
+		}
+	}
+}
+
+// has to be called with the lock held
+func (this *Tournament) isFinished() bool {
+	switch this.Format {
+		case TOURNAMENT_ROUND_ROBIN:
+			return this.Round >= 1
+		case TOURNAMENT_SWISS:
+			return this.Round >= this.Rounds
+		default:
+			return len(this.getAdvancing(this.Round)) <= 1
+	}
+}
+
+// has to be called with the lock held
+func (this *Tournament) isRoundDone() bool {
+	for _,match := range this.Matches {
+		if match.Round == this.Round && !match.Done {
+			return false
+		}
+	}
+	return true
+}
+
+// has to be called with the lock held
+func (this *Tournament) makePairings() [][]int {
+	switch this.Format {
+		case TOURNAMENT_ROUND_ROBIN:
+			return this.makeRoundRobinPairings()
+		case TOURNAMENT_SWISS:
+			return this.makeSwissPairings()
+		default:
+			return this.makeKnockoutPairings()
+	}
+}
+
+// every bot plays every other bot once
+func (this *Tournament) makeRoundRobinPairings() [][]int {
+	result := [][]int{}
+	for i := 0; i < len(this.Bots); i++ {
+		for j := i+1; j < len(this.Bots); j++ {
+			result = append(result, []int{this.Bots[i].GetId(), this.Bots[j].GetId()})
+		}
+	}
+	return result
+}
+
+// bots with similar points play each other, avoiding rematches when possible
+// with an odd number of bots the lowest ranked bot without a bye gets one
+func (this *Tournament) makeSwissPairings() [][]int {
+	result := [][]int{}
+	unpaired := []*Standing{}
+	for _,standing := range this.Standings {
+		unpaired = append(unpaired, standing)
+	}
+
+	if len(unpaired) % 2 == 1 {
+		bye := len(unpaired) - 1
+		for i := len(unpaired) - 1; i >= 0; i-- {
+			if unpaired[i].Byes == 0 {
+				bye = i
+				break
+			}
+		}
+		result = append(result, []int{unpaired[bye].Bot})
+		unpaired = append(unpaired[:bye], unpaired[bye+1:]...)
+	}
+
+	for len(unpaired) > 0 {
+		first := unpaired[0]
+		opponent := 1
+		for i := 1; i < len(unpaired); i++ {
+			if !this.havePlayed(first.Bot, unpaired[i].Bot) {
+				opponent = i
+				break
+			}
+		}
+		result = append(result, []int{first.Bot, unpaired[opponent].Bot})
+		unpaired = append(unpaired[1:opponent], unpaired[opponent+1:]...)
+	}
+	return result
+}
+
+// the best remaining seed plays the worst remaining seed
+// the best seeds get a bye in the first round when the number of bots is not a power of two
+func (this *Tournament) makeKnockoutPairings() [][]int {
+	result := [][]int{}
+	advancing := this.getAdvancing(this.Round - 1)
+	seeds := map[int]int{}
+	for i,bot := range this.Bots {
+		seeds[bot.GetId()] = i
+	}
+	sort.SliceStable(advancing, func(i, j int) bool {
+		return seeds[advancing[i]] < seeds[advancing[j]]
+	})
+
+	if this.Round == 1 {
+		size := 1
+		for size < len(advancing) {
+			size *= 2
+		}
+		for _,bot := range advancing[:size - len(advancing)] {
+			result = append(result, []int{bot})
+		}
+		advancing = advancing[size - len(advancing):]
+	}
+	for i, j := 0, len(advancing) - 1; i <= j; i, j = i+1, j-1 {
+		if i == j {
+			result = append(result, []int{advancing[i]})
+		} else {
+			result = append(result, []int{advancing[i], advancing[j]})
+		}
+	}
+	return result
+}
+
+// the bots that advance from the given round of a knockout
+// before the first round every bot advances
+func (this *Tournament) getAdvancing(round int) []int {
+	result := []int{}
+	if round == 0 {
+		for _,bot := range this.Bots {
+			result = append(result, bot.GetId())
+		}
+		return result
+	}
+	for _,match := range this.Matches {
+		if match.Round == round && match.Done {
+			result = append(result, match.Winner)
+		}
+	}
+	return result
+}
+
+func (this *Tournament) havePlayed(a int, b int) bool {
+	for _,match := range this.Matches {
+		if len(match.Bots) == 2 && ((match.Bots[0] == a && match.Bots[1] == b) || (match.Bots[0] == b && match.Bots[1] == a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// updates the standings with a finished match, has to be called with the lock held
+func (this *Tournament) recordMatch(match *Match) {
+	for _,id := range match.Bots {
+		standing := this.getStanding(id)
+		if standing == nil {
+			continue
+		}
+		switch {
+			case len(match.Bots) == 1:
+				standing.Byes++
+				standing.Points += 1
+			case match.Draw:
+				standing.Played++
+				standing.Draws++
+				standing.Points += 0.5
+			case match.Winner == id:
+				standing.Played++
+				standing.Wins++
+				standing.Points += 1
+			default:
+				standing.Played++
+				standing.Losses++
+		}
+		if this.Format == TOURNAMENT_KNOCKOUT && match.Winner != id {
+			standing.Eliminated = true
+		}
+	}
+	sort.SliceStable(this.Standings, func(i, j int) bool {
+		if this.Standings[i].Points != this.Standings[j].Points {
+			return this.Standings[i].Points > this.Standings[j].Points
+		}
+		return this.Standings[i].Wins > this.Standings[j].Wins
+	})
+}
+
+func (this *Tournament) getStanding(bot int) *Standing {
+	for _,standing := range this.Standings {
+		if standing.Bot == bot {
+			return standing
+		}
+	}
+	return nil
+}
+
+
+
+// getters and setters
+
+func (this *Tournament) GetId() int {
+	this.RLock()
+	defer this.RUnlock()
+	return this.Id
+}
+
+func (this *Tournament) GetName() string {
+	this.RLock()
+	defer this.RUnlock()
+	return this.Name
+}
+
+func (this *Tournament) getEngine() *Client {
+	this.RLock()
+	defer this.RUnlock()
+	return this.Engine
+}
+
+func (this *Tournament) GetMoveTimeout() int {
+	this.RLock()
+	defer this.RUnlock()
+	return this.MoveTimeout
+}
+
+func (this *Tournament) SetMoveTimeout(moveTimeout int) {
+	this.Lock()
+	defer this.Unlock()
+	this.MoveTimeout = moveTimeout
+}
+
+func (this *Tournament) GetStopped() bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.Stopped
+}
+
+func (this *Tournament) getLobby() *Lobby {
+	this.RLock()
+	defer this.RUnlock()
+	return this.lobby
+}
+
+func (this *Tournament) setLobby(lobby *Lobby) {
+	this.Lock()
+	defer this.Unlock()
+	this.lobby = lobby
+}
+
+
+
+// lock for json marshalling
+
+type JTournament Tournament
+
+func (this *Tournament) MarshalJSON() ([]byte, error) {
+	this.RLock()
+	defer this.RUnlock()
+	return json.Marshal(JTournament(*this))
+}
@@ -0,0 +1,98 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	sync "github.com/sasha-s/go-deadlock"
+	msg "github.com/Project-Wartemis/pw-backend/internal/message"
+)
+
+// keeps track of which players still have to act in a turn
+type Turn struct {
+	sync.RWMutex
+	number int
+	movers map[int]bool // ids of the players that were asked to move
+	done map[int]bool   // ids of the players that acted or timed out
+	actions []*msg.ActionMessage
+	flushed bool
+	over bool // the engine sent the next state, players that did not act yet are too late
+}
+
+func NewTurn(number int, movers []int) *Turn {
+	turn := &Turn {
+		number: number,
+		movers: map[int]bool{},
+		done: map[int]bool{},
+		actions: []*msg.ActionMessage{},
+		flushed: false,
+		over: false,
+	}
+	for _,id := range movers {
+		turn.movers[id] = true
+	}
+	return turn
+}
+
+// registers the action of a player, or returns why it is not accepted
+func (this *Turn) AddAction(playerId int, message *msg.ActionMessage) error {
+	this.Lock()
+	defer this.Unlock()
+	if this.over || (message.Turn != nil && *message.Turn < this.number) {
+		number := this.number
+		if message.Turn != nil {
+			number = *message.Turn
+		}
+		return errors.New(fmt.Sprintf("Turn [%d] is over", number))
+	}
+	if message.Turn != nil && *message.Turn != this.number {
+		return errors.New(fmt.Sprintf("Action is for turn [%d], but the current turn is [%d]", *message.Turn, this.number))
+	}
+	if !this.movers[playerId] {
+		return errors.New(fmt.Sprintf("You were not asked to move in turn [%d]", this.number))
+	}
+	if this.done[playerId] {
+		return errors.New(fmt.Sprintf("You already acted in turn [%d]", this.number))
+	}
+	this.done[playerId] = true
+	this.actions = append(this.actions, message)
+	return nil
+}
+
+// marks a player that did not act in time as done, returns false if the player already acted
+func (this *Turn) Skip(playerId int) bool {
+	this.Lock()
+	defer this.Unlock()
+	if !this.movers[playerId] || this.done[playerId] {
+		return false
+	}
+	this.done[playerId] = true
+	return true
+}
+
+// no more actions are accepted, the ones that were are returned by TakeActions
+func (this *Turn) End() {
+	this.Lock()
+	defer this.Unlock()
+	this.over = true
+}
+
+// returns the actions of this turn once every mover is done or the turn is over, and nil otherwise
+// the actions are only returned once
+func (this *Turn) TakeActions() []*msg.ActionMessage {
+	this.Lock()
+	defer this.Unlock()
+	if this.flushed || len(this.movers) == 0 {
+		return nil
+	}
+	if len(this.done) < len(this.movers) && (!this.over || len(this.actions) == 0) {
+		return nil // still waiting on players, or the turn is over without a single action
+	}
+	this.flushed = true
+	return this.actions
+}
+
+func (this *Turn) GetNumber() int {
+	this.RLock()
+	defer this.RUnlock()
+	return this.number
+}
@@ -1,13 +1,51 @@
 package http
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	sync "github.com/sasha-s/go-deadlock"
 	log "github.com/sirupsen/logrus"
 	"github.com/Project-Wartemis/pw-backend/internal/base"
 )
 
+const (
+	MAX_REPLAY_SIZE = 64 << 20 // 64 MiB
+)
+
+type newGameRequest struct {
+	Name string      `json:"name"`
+	Engine int       `json:"engine"`
+	MoveTimeout int  `json:"moveTimeout"`
+	Autoplay *bool   `json:"autoplay"`
+	ReconnectTimeout int `json:"reconnectTimeout"`
+	Forfeit string   `json:"forfeit"`
+}
+
+type newPlayerRequest struct {
+	Bot int `json:"bot"`
+}
+
+type newTournamentRequest struct {
+	Name string      `json:"name"`
+	Engine int       `json:"engine"`
+	Bots []int       `json:"bots"`
+	Format string    `json:"format"`
+	Concurrency int  `json:"concurrency"`
+	Rounds int       `json:"rounds"` // swiss only, optional
+	MoveTimeout int  `json:"moveTimeout"`
+}
+
+type newTokenRequest struct {
+	ClientType string `json:"clientType"`
+	Name string       `json:"name"`
+}
+
 type LobbyHttpInterface struct {
 	sync.RWMutex
 	lobby *base.Lobby
@@ -50,6 +88,304 @@ func (this *LobbyHttpInterface) HandleNewConnection(writer http.ResponseWriter,
 
 
 
+// rest api
+
+func (this *LobbyHttpInterface) HandleGetLobby(writer http.ResponseWriter, request *http.Request) {
+	WriteJson(writer, this.getLobby())
+}
+
+func (this *LobbyHttpInterface) HandleGetGames(writer http.ResponseWriter, request *http.Request) {
+	WriteJson(writer, this.getLobby().GetGames())
+}
+
+func (this *LobbyHttpInterface) HandleGetGame(writer http.ResponseWriter, request *http.Request) {
+	game := this.getGameFromRequest(writer, request)
+	if game == nil {
+		return
+	}
+	WriteJson(writer, game)
+}
+
+func (this *LobbyHttpInterface) HandleGetClients(writer http.ResponseWriter, request *http.Request) {
+	WriteJson(writer, this.getLobby().GetClients())
+}
+
+func (this *LobbyHttpInterface) HandlePostGame(writer http.ResponseWriter, request *http.Request) {
+	if !this.authorize(writer, request) {
+		return
+	}
+
+	body := &newGameRequest{}
+	err := json.NewDecoder(request.Body).Decode(body)
+	if err != nil {
+		WriteStatus(writer, http.StatusBadRequest, "Could not parse request", err)
+		return
+	}
+
+	engine := this.getLobby().GetClientById(body.Engine)
+	if engine == nil {
+		WriteStatus(writer, http.StatusNotFound, "Engine not found", errors.New(fmt.Sprintf("Could not find engine with id [%d]", body.Engine)))
+		return
+	}
+	if engine.GetType() != base.TYPE_ENGINE {
+		WriteStatus(writer, http.StatusBadRequest, "Client is not an engine", errors.New(fmt.Sprintf("Client [%d] is not an engine", body.Engine)))
+		return
+	}
+
+	if body.MoveTimeout < 0 {
+		WriteStatus(writer, http.StatusBadRequest, "Invalid move timeout", errors.New(fmt.Sprintf("Move timeout [%d] cannot be negative", body.MoveTimeout)))
+		return
+	}
+
+	if body.ReconnectTimeout < 0 {
+		WriteStatus(writer, http.StatusBadRequest, "Invalid reconnect timeout", errors.New(fmt.Sprintf("Reconnect timeout [%d] cannot be negative", body.ReconnectTimeout)))
+		return
+	}
+
+	game := base.NewGame(body.Name, engine)
+	game.SetMoveTimeout(body.MoveTimeout)
+	if body.Autoplay != nil {
+		game.SetAutoplay(*body.Autoplay)
+	}
+	game.SetReconnectTimeout(body.ReconnectTimeout)
+	if body.Forfeit != "" {
+		err = game.SetForfeitPolicy(body.Forfeit)
+		if err != nil {
+			WriteStatus(writer, http.StatusBadRequest, "Invalid forfeit policy", err)
+			return
+		}
+	}
+	this.getLobby().AddGame(game)
+	writeJsonWithStatus(writer, http.StatusCreated, game)
+}
+
+func (this *LobbyHttpInterface) HandlePostPlayer(writer http.ResponseWriter, request *http.Request) {
+	if !this.authorize(writer, request) {
+		return
+	}
+
+	game := this.getGameFromRequest(writer, request)
+	if game == nil {
+		return
+	}
+
+	body := &newPlayerRequest{}
+	err := json.NewDecoder(request.Body).Decode(body)
+	if err != nil {
+		WriteStatus(writer, http.StatusBadRequest, "Could not parse request", err)
+		return
+	}
+
+	bot := this.getLobby().GetClientById(body.Bot)
+	if bot == nil {
+		WriteStatus(writer, http.StatusNotFound, "Bot not found", errors.New(fmt.Sprintf("Bot [%d] not found", body.Bot)))
+		return
+	}
+	if bot.GetType() != base.TYPE_BOT {
+		WriteStatus(writer, http.StatusBadRequest, "Client is not a bot", errors.New(fmt.Sprintf("Client [%d] is not a bot", body.Bot)))
+		return
+	}
+
+	err = game.AddPlayer(bot)
+	if err != nil {
+		WriteStatus(writer, http.StatusConflict, "Could not invite bot", err)
+		return
+	}
+
+	this.getLobby().TriggerUpdated()
+	WriteJson(writer, game)
+}
+
+func (this *LobbyHttpInterface) HandlePostStart(writer http.ResponseWriter, request *http.Request) {
+	if !this.authorize(writer, request) {
+		return
+	}
+
+	game := this.getGameFromRequest(writer, request)
+	if game == nil {
+		return
+	}
+
+	err := game.Start()
+	if err != nil {
+		WriteStatus(writer, http.StatusConflict, "Could not start game", err)
+		return
+	}
+
+	this.getLobby().TriggerUpdated()
+	WriteJson(writer, game)
+}
+
+func (this *LobbyHttpInterface) HandleGetReplay(writer http.ResponseWriter, request *http.Request) {
+	game := this.getGameFromRequest(writer, request)
+	if game == nil {
+		return
+	}
+
+	replay, err := game.ToReplay()
+	if err != nil {
+		WriteStatus(writer, http.StatusConflict, "Could not export replay", err)
+		return
+	}
+
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"game-%d.replay.json\"", game.GetId()))
+	WriteJson(writer, replay)
+}
+
+func (this *LobbyHttpInterface) HandlePostReplay(writer http.ResponseWriter, request *http.Request) {
+	if !this.authorize(writer, request) {
+		return
+	}
+
+	replay := &base.Replay{}
+	err := json.NewDecoder(http.MaxBytesReader(writer, request.Body, MAX_REPLAY_SIZE)).Decode(replay)
+	if err != nil {
+		WriteStatus(writer, http.StatusBadRequest, "Could not parse replay", err)
+		return
+	}
+
+	game, err := this.getLobby().ImportReplay(replay)
+	if err != nil {
+		WriteStatus(writer, http.StatusBadRequest, "Could not import replay", err)
+		return
+	}
+
+	writeJsonWithStatus(writer, http.StatusCreated, game)
+}
+
+// the ratings for a single game with ?engine=..., otherwise for all games
+func (this *LobbyHttpInterface) HandleGetLeaderboard(writer http.ResponseWriter, request *http.Request) {
+	leaderboard := this.getLobby().GetLeaderboard()
+	engine := request.URL.Query().Get("engine")
+	if engine == "" {
+		WriteJson(writer, leaderboard)
+		return
+	}
+	WriteJson(writer, leaderboard.GetRatings(engine))
+}
+
+func (this *LobbyHttpInterface) HandleGetTournaments(writer http.ResponseWriter, request *http.Request) {
+	WriteJson(writer, this.getLobby().GetTournaments())
+}
+
+func (this *LobbyHttpInterface) HandleGetTournament(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(request)["id"])
+	if err != nil {
+		WriteStatus(writer, http.StatusBadRequest, "Invalid tournament id", err)
+		return
+	}
+	tournament := this.getLobby().GetTournamentById(id)
+	if tournament == nil {
+		WriteStatus(writer, http.StatusNotFound, "Tournament not found", errors.New(fmt.Sprintf("Tournament [%d] not found", id)))
+		return
+	}
+	WriteJson(writer, tournament)
+}
+
+func (this *LobbyHttpInterface) HandlePostTournament(writer http.ResponseWriter, request *http.Request) {
+	if !this.authorize(writer, request) {
+		return
+	}
+
+	body := &newTournamentRequest{}
+	err := json.NewDecoder(request.Body).Decode(body)
+	if err != nil {
+		WriteStatus(writer, http.StatusBadRequest, "Could not parse request", err)
+		return
+	}
+
+	engine := this.getLobby().GetClientById(body.Engine)
+	if engine == nil {
+		WriteStatus(writer, http.StatusNotFound, "Engine not found", errors.New(fmt.Sprintf("Could not find engine with id [%d]", body.Engine)))
+		return
+	}
+	bots := []*base.Client{}
+	for _,id := range body.Bots {
+		bot := this.getLobby().GetClientById(id)
+		if bot == nil {
+			WriteStatus(writer, http.StatusNotFound, "Bot not found", errors.New(fmt.Sprintf("Bot [%d] not found", id)))
+			return
+		}
+		bots = append(bots, bot)
+	}
+	if body.MoveTimeout < 0 {
+		WriteStatus(writer, http.StatusBadRequest, "Invalid move timeout", errors.New(fmt.Sprintf("Move timeout [%d] cannot be negative", body.MoveTimeout)))
+		return
+	}
+
+	tournament, err := base.NewTournament(body.Name, engine, bots, body.Format, body.Concurrency, body.Rounds)
+	if err != nil {
+		WriteStatus(writer, http.StatusBadRequest, "Could not create tournament", err)
+		return
+	}
+	tournament.SetMoveTimeout(body.MoveTimeout)
+	this.getLobby().AddTournament(tournament)
+	writeJsonWithStatus(writer, http.StatusCreated, tournament)
+}
+
+// admin only
+func (this *LobbyHttpInterface) HandlePostToken(writer http.ResponseWriter, request *http.Request) {
+	if !this.isAdmin(request) {
+		WriteStatus(writer, http.StatusForbidden, "Not allowed to issue tokens", errors.New("A valid admin token is required"))
+		return
+	}
+
+	body := &newTokenRequest{}
+	err := json.NewDecoder(request.Body).Decode(body)
+	if err != nil {
+		WriteStatus(writer, http.StatusBadRequest, "Could not parse request", err)
+		return
+	}
+
+	token, err := this.getLobby().IssueToken(body.ClientType, body.Name)
+	if err != nil {
+		WriteStatus(writer, http.StatusBadRequest, "Could not issue token", err)
+		return
+	}
+
+	writeJsonWithStatus(writer, http.StatusCreated, token)
+}
+
+// changes through the api require the admin token when authentication is enabled
+// writes an error to the response and returns false if not allowed
+func (this *LobbyHttpInterface) authorize(writer http.ResponseWriter, request *http.Request) bool {
+	if !this.getLobby().GetAuthenticator().IsEnabled() || this.isAdmin(request) {
+		return true
+	}
+	WriteStatus(writer, http.StatusForbidden, "Not allowed", errors.New("A valid admin token is required"))
+	return false
+}
+
+// checks the "Authorization: Bearer <token>" header against the admin token
+func (this *LobbyHttpInterface) isAdmin(request *http.Request) bool {
+	header := request.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	return this.getLobby().GetAuthenticator().IsAdmin(strings.TrimPrefix(header, "Bearer "))
+}
+
+// writes an error to the response and returns nil if the game cannot be found
+// archived games can only be read
+func (this *LobbyHttpInterface) getGameFromRequest(writer http.ResponseWriter, request *http.Request) *base.Game {
+	id, err := strconv.Atoi(mux.Vars(request)["id"])
+	if err != nil {
+		WriteStatus(writer, http.StatusBadRequest, "Invalid game id", err)
+		return nil
+	}
+	game := this.getLobby().GetGameById(id)
+	if game == nil && request.Method == http.MethodGet {
+		game = this.getLobby().GetArchivedGame(id)
+	}
+	if game == nil {
+		WriteStatus(writer, http.StatusNotFound, "Game not found", errors.New(fmt.Sprintf("Game [%d] not found", id)))
+		return nil
+	}
+	return game
+}
+
+
+
 // getters and setters
 
 func (this *LobbyHttpInterface) getLobby() *base.Lobby {
@@ -1,26 +1,42 @@
 package http
 
 import (
-	"fmt"
 	"encoding/json"
 	"net/http"
 	log "github.com/sirupsen/logrus"
 )
 
-// not actually used anywhere anymore
+type statusResponse struct {
+	Message string  `json:"message"`
+	Errors []string `json:"errors"`
+}
+
 func WriteStatus(writer http.ResponseWriter, status int, message string, errors ...error) {
 	log.Warnf("%s: %s", message, errors)
+	response := statusResponse {
+		Message: message,
+		Errors: []string{},
+	}
+	for _,err := range errors {
+		response.Errors = append(response.Errors, err.Error())
+	}
+	text, _ := json.Marshal(response)
+	writer.Header().Set("Content-Type", "application/json")
 	writer.WriteHeader(status)
-	writer.Write([]byte(fmt.Sprintf(`{"message": "%s", "errors": %s}`, message, errors)))
+	writer.Write(text)
 }
 
-// not actually used anywhere anymore
 func WriteJson(writer http.ResponseWriter, value interface{}) {
-	json, err := json.Marshal(value)
+	writeJsonWithStatus(writer, http.StatusOK, value)
+}
+
+func writeJsonWithStatus(writer http.ResponseWriter, status int, value interface{}) {
+	text, err := json.Marshal(value)
 	if err != nil {
-		WriteStatus(writer, http.StatusBadRequest, "Error parsing object to json", err)
+		WriteStatus(writer, http.StatusInternalServerError, "Error parsing object to json", err)
+		return
 	}
 	writer.Header().Set("Content-Type", "application/json")
-	writer.WriteHeader(http.StatusOK)
-	writer.Write(json)
+	writer.WriteHeader(status)
+	writer.Write(text)
 }
@@ -1,6 +1,7 @@
 package master
 
 import (
+	"expvar"
 	"flag"
 	"fmt"
 	"net/http"
@@ -32,6 +33,24 @@ func (this *Router) Start(port int) {
 
 func (this *Router) Initialise(LobbyInterface *http2.LobbyHttpInterface) {
 	this.router.HandleFunc("/socket", LobbyInterface.HandleNewConnection)
+	this.router.Handle("/debug/vars", expvar.Handler())
+
+	api := this.router.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/lobby",              LobbyInterface.HandleGetLobby).Methods(http.MethodGet)
+	api.HandleFunc("/games",              LobbyInterface.HandleGetGames).Methods(http.MethodGet)
+	api.HandleFunc("/games",              LobbyInterface.HandlePostGame).Methods(http.MethodPost)
+	api.HandleFunc("/games/{id}",         LobbyInterface.HandleGetGame).Methods(http.MethodGet)
+	api.HandleFunc("/games/{id}/players", LobbyInterface.HandlePostPlayer).Methods(http.MethodPost)
+	api.HandleFunc("/games/{id}/start",   LobbyInterface.HandlePostStart).Methods(http.MethodPost)
+	api.HandleFunc("/games/{id}/replay",  LobbyInterface.HandleGetReplay).Methods(http.MethodGet)
+	api.HandleFunc("/replays",            LobbyInterface.HandlePostReplay).Methods(http.MethodPost)
+	api.HandleFunc("/clients",            LobbyInterface.HandleGetClients).Methods(http.MethodGet)
+	api.HandleFunc("/tournaments",        LobbyInterface.HandleGetTournaments).Methods(http.MethodGet)
+	api.HandleFunc("/tournaments",        LobbyInterface.HandlePostTournament).Methods(http.MethodPost)
+	api.HandleFunc("/tournaments/{id}",   LobbyInterface.HandleGetTournament).Methods(http.MethodGet)
+	api.HandleFunc("/leaderboard",        LobbyInterface.HandleGetLeaderboard).Methods(http.MethodGet)
+	api.HandleFunc("/tokens",             LobbyInterface.HandlePostToken).Methods(http.MethodPost)
+
 	this.router.HandleFunc("/*",      NotFoundHandler)
 }
 
@@ -12,38 +12,79 @@ type Message struct {
 type ActionMessage struct { // also outgoing
 	Message
 	Game int               `json:"game"`
-	Key string             `json:"key"` // incoming only
+	Turn *int              `json:"turn,omitempty"` // optional when incoming
+	Key string             `json:"key,omitempty"` // incoming only, never forwarded to the engine
 	Player string          `json:"player"` // outgoing only
 	Action json.RawMessage `json:"action"`
 }
 
 type GameMessage struct {
 	Message
-	Name string
-	Engine int
+	Name string      `json:"name"`
+	Engine int       `json:"engine"`
+	MoveTimeout int  `json:"moveTimeout"` // in milliseconds, optional
+	Autoplay *bool   `json:"autoplay"` // optional, defaults to true
+	ReconnectTimeout int `json:"reconnectTimeout"` // in milliseconds, optional
+	Forfeit string   `json:"forfeit"` // optional, eliminate or remove
+}
+
+type HistoryRequestMessage struct {
+	Message
+	Game int  `json:"game"`
+	From int  `json:"from"`
+	To *int   `json:"to"` // inclusive, optional
 }
 
 type InviteMessage struct {
 	Message
-	Game int
-	Bot int
+	Game int `json:"game"`
+	Bot int  `json:"bot"`
 }
 
 type JoinMessage struct {
 	Message
-	Game int
+	Game int       `json:"game"`
+	History *bool  `json:"history"` // optional, send the full history instead of only the latest turn, defaults to true
+	Format string  `json:"format"` // optional, "full" or "delta", defaults to "full"
 }
 
 type LeaveMessage struct {
 	Message
-	Game int
+	Game int `json:"game"`
+}
+
+type PauseMessage struct { // also outgoing
+	Message
+	Game int `json:"game"`
+}
+
+type LeaderboardRequestMessage struct {
+	Message
+	Engine string `json:"engine"` // the game the engine runs
+}
+
+type QueueMessage struct {
+	Message
+	Game string `json:"game"` // optional, the game the bot registered with
 }
 
 type RegisterMessage struct {
 	Message
-	ClientType string
-	Name string
-	Game string
+	ClientType string            `json:"clientType"`
+	Name string                  `json:"name"`
+	Game string                  `json:"game"`
+	ActionSchema json.RawMessage `json:"actionSchema"` // engines only, optional
+	StateSchema json.RawMessage  `json:"stateSchema"` // engines only, optional
+	BatchActions bool            `json:"batchActions"` // engines only, receive all actions of a turn at once
+	Token string                 `json:"token"` // bots and engines, required when authentication is enabled
+	AcceptInvites bool           `json:"acceptInvites"` // bots only, accept invites to games of any engine
+	Players int                  `json:"players"` // engines only, the number of players in a game created by the matchmaker
+	ResumeToken string           `json:"resumeToken"` // bots and engines, the resume token of a disconnected client to take over
+}
+
+type ResumeMessage struct { // also outgoing
+	Message
+	Game int `json:"game"`
 }
 
 type StartMessage struct { // also outgoing
@@ -56,17 +97,32 @@ type StartMessage struct { // also outgoing
 
 type StateMessage struct {
 	Message
-	Game int
-	Turn int
-	Players []string
-	State json.RawMessage
+	Game int                           `json:"game"`
+	Turn int                           `json:"turn"`
+	Players []string                   `json:"players"`
+	State json.RawMessage              `json:"state"` // sent to viewers, and to players without their own state
+	States map[string]json.RawMessage  `json:"states,omitempty"` // optional, the state per padded player id
 }
 
-type StopMessage struct { // also outgoing
+type StepMessage struct { // also outgoing
 	Message
 	Game int `json:"game"`
 }
 
+type StopMessage struct { // also outgoing
+	Message
+	Game int            `json:"game"`
+	Result *StopResult  `json:"result,omitempty"` // incoming only, optional
+}
+
+// the result as reported by the engine, players are referenced by their padded id
+type StopResult struct {
+	Winners []string          `json:"winners"`
+	Scores map[string]float64 `json:"scores"`
+	Ranks map[string]int      `json:"ranks"`
+	Reason string             `json:"reason"`
+}
+
 func ParseMessage(raw []byte) (*Message, error) {
 	message := &Message{}
 	err := json.Unmarshal(raw, message)
@@ -97,6 +153,16 @@ func ParseGameMessage(raw []byte) (*GameMessage, error) {
 	return message, nil
 }
 
+func ParseHistoryRequestMessage(raw []byte) (*HistoryRequestMessage, error) {
+	message := &HistoryRequestMessage{}
+	err := json.Unmarshal(raw, message)
+	if err != nil {
+		log.Warnf("Could not parse HistoryRequestMessage [%s]", raw)
+		return nil, err
+	}
+	return message, nil
+}
+
 func ParseInviteMessage(raw []byte) (*InviteMessage, error) {
 	message := &InviteMessage{}
 	err := json.Unmarshal(raw, message)
@@ -127,6 +193,36 @@ func ParseLeaveMessage(raw []byte) (*LeaveMessage, error) {
 	return message, nil
 }
 
+func ParsePauseMessage(raw []byte) (*PauseMessage, error) {
+	message := &PauseMessage{}
+	err := json.Unmarshal(raw, message)
+	if err != nil {
+		log.Warnf("Could not parse PauseMessage [%s]", raw)
+		return nil, err
+	}
+	return message, nil
+}
+
+func ParseLeaderboardRequestMessage(raw []byte) (*LeaderboardRequestMessage, error) {
+	message := &LeaderboardRequestMessage{}
+	err := json.Unmarshal(raw, message)
+	if err != nil {
+		log.Warnf("Could not parse LeaderboardRequestMessage [%s]", raw)
+		return nil, err
+	}
+	return message, nil
+}
+
+func ParseQueueMessage(raw []byte) (*QueueMessage, error) {
+	message := &QueueMessage{}
+	err := json.Unmarshal(raw, message)
+	if err != nil {
+		log.Warnf("Could not parse QueueMessage [%s]", raw)
+		return nil, err
+	}
+	return message, nil
+}
+
 func ParseRegisterMessage(raw []byte) (*RegisterMessage, error) {
 	message := &RegisterMessage{}
 	err := json.Unmarshal(raw, message)
@@ -137,6 +233,16 @@ func ParseRegisterMessage(raw []byte) (*RegisterMessage, error) {
 	return message, nil
 }
 
+func ParseResumeMessage(raw []byte) (*ResumeMessage, error) {
+	message := &ResumeMessage{}
+	err := json.Unmarshal(raw, message)
+	if err != nil {
+		log.Warnf("Could not parse ResumeMessage [%s]", raw)
+		return nil, err
+	}
+	return message, nil
+}
+
 func ParseStartMessage(raw []byte) (*StartMessage, error) {
 	message := &StartMessage{}
 	err := json.Unmarshal(raw, message)
@@ -170,6 +276,16 @@ func ParseStateMessage(raw []byte) (*StateMessage, error) {
 	return message, nil
 }
 
+func ParseStepMessage(raw []byte) (*StepMessage, error) {
+	message := &StepMessage{}
+	err := json.Unmarshal(raw, message)
+	if err != nil {
+		log.Warnf("Could not parse StepMessage [%s]", raw)
+		return nil, err
+	}
+	return message, nil
+}
+
 func ParseStopMessage(raw []byte) (*StopMessage, error) {
 	message := &StopMessage{}
 	err := json.Unmarshal(raw, message)
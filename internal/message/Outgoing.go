@@ -2,6 +2,7 @@ package message
 
 import (
 	"encoding/json"
+	"github.com/Project-Wartemis/pw-backend/internal/patch"
 )
 
 type ConnectedMessage struct {
@@ -15,7 +16,8 @@ type CreatedMessage struct {
 
 type ErrorMessage struct {
 	Message
-	Error string `json:"message"`
+	Error string     `json:"message"`
+	Errors []string  `json:"errors,omitempty"`
 }
 
 type LobbyMessage struct {
@@ -25,7 +27,8 @@ type LobbyMessage struct {
 
 type RegisteredMessage struct {
 	Message
-	Id int `json:"id"`
+	Id int             `json:"id"`
+	ResumeToken string `json:"resumeToken,omitempty"` // bots and engines, send this in the register message after a reconnect
 }
 
 type StateMessageOut struct {
@@ -37,9 +40,93 @@ type StateMessageOut struct {
 	State json.RawMessage `json:"state"`
 }
 
+type ResultMessage struct {
+	Message
+	Game int            `json:"game"`
+	Result *GameResult  `json:"result"`
+}
+
+// the result of a game, players are referenced by their id
+type GameResult struct {
+	Winners []int          `json:"winners"`
+	Scores map[int]float64 `json:"scores,omitempty"`
+	Ranks map[int]int      `json:"ranks,omitempty"`
+	Reason string          `json:"reason"`
+}
+
+type ActionsMessage struct {
+	Message
+	Game int                  `json:"game"`
+	Turn int                  `json:"turn"`
+	Actions []*ActionMessage  `json:"actions"`
+}
+
+type TimeoutMessage struct {
+	Message
+	Game int      `json:"game"`
+	Player string `json:"player"`
+	Turn int      `json:"turn"`
+}
+
+// the changes to the state of turn From that result in the state of turn Turn
+type DeltaMessage struct {
+	Message
+	Game int                  `json:"game"`
+	Turn int                  `json:"turn"`
+	From int                  `json:"from"`
+	Patch []*patch.Operation  `json:"patch"`
+}
+
+type TournamentMessage struct {
+	Message
+	Tournament interface{} `json:"tournament"`
+}
+
+type LeaderboardMessage struct {
+	Message
+	Engine string        `json:"engine"`
+	Ratings interface{}  `json:"ratings"`
+}
+
+type DisconnectedMessage struct {
+	Message
+	Game int      `json:"game"`
+	Player string `json:"player"`
+}
+
+type ReconnectedMessage struct {
+	Message
+	Game int      `json:"game"`
+	Player string `json:"player"`
+}
+
+type ForfeitMessage struct {
+	Message
+	Game int      `json:"game"`
+	Player string `json:"player"`
+	Policy string `json:"policy"` // remove or eliminate
+}
+
+type AbortedMessage struct {
+	Message
+	Game int      `json:"game"`
+	Reason string `json:"reason"`
+}
+
+type QueuedMessage struct {
+	Message
+	Game string `json:"game"`
+}
+
+type MatchedMessage struct {
+	Message
+	Game int `json:"game"`
+}
+
 type HistoryMessage struct {
 	Message
 	Messages []*StateMessageOut `json:"messages"`
+	Turns int                   `json:"turns"` // the number of turns available
 }
 
 func NewConnectedMessage() *ConnectedMessage {
@@ -71,6 +158,12 @@ func NewErrorMessage(error string) *ErrorMessage {
 	}
 }
 
+func NewValidationErrorMessage(error string, errors []string) *ErrorMessage {
+	message := NewErrorMessage(error)
+	message.Errors = errors
+	return message
+}
+
 func NewLobbyMessage(lobby interface{}) *LobbyMessage {
 	message := Message {
 		Type: "lobby",
@@ -81,13 +174,14 @@ func NewLobbyMessage(lobby interface{}) *LobbyMessage {
 	}
 }
 
-func NewRegisteredMessage(id int) *RegisteredMessage {
+func NewRegisteredMessage(id int, resumeToken string) *RegisteredMessage {
 	message := Message {
 		Type: "registered",
 	}
 	return &RegisteredMessage {
 		Message: message,
 		Id: id,
+		ResumeToken: resumeToken,
 	}
 }
 
@@ -105,12 +199,147 @@ func NewStateMessageOut(game int, key string, turn int, move bool, state string)
 	}
 }
 
-func NewHistoryMessage(messages []*StateMessageOut) *HistoryMessage {
+func NewResultMessage(game int, result *GameResult) *ResultMessage {
+	message := Message {
+		Type: "result",
+	}
+	return &ResultMessage {
+		Message: message,
+		Game: game,
+		Result: result,
+	}
+}
+
+func NewActionsMessage(game int, turn int, actions []*ActionMessage) *ActionsMessage {
+	message := Message {
+		Type: "actions",
+	}
+	return &ActionsMessage {
+		Message: message,
+		Game: game,
+		Turn: turn,
+		Actions: actions,
+	}
+}
+
+func NewTimeoutMessage(game int, player string, turn int) *TimeoutMessage {
+	message := Message {
+		Type: "timeout",
+	}
+	return &TimeoutMessage {
+		Message: message,
+		Game: game,
+		Player: player,
+		Turn: turn,
+	}
+}
+
+func NewDeltaMessage(game int, turn int, from int, operations []*patch.Operation) *DeltaMessage {
+	message := Message {
+		Type: "delta",
+	}
+	return &DeltaMessage {
+		Message: message,
+		Game: game,
+		Turn: turn,
+		From: from,
+		Patch: operations,
+	}
+}
+
+func NewHistoryMessage(messages []*StateMessageOut, turns int) *HistoryMessage {
 	message := Message {
 		Type: "history",
 	}
 	return &HistoryMessage {
 		Message: message,
 		Messages: messages,
+		Turns: turns,
+	}
+}
+
+func NewQueuedMessage(game string) *QueuedMessage {
+	message := Message {
+		Type: "queued",
+	}
+	return &QueuedMessage {
+		Message: message,
+		Game: game,
+	}
+}
+
+func NewMatchedMessage(game int) *MatchedMessage {
+	message := Message {
+		Type: "matched",
+	}
+	return &MatchedMessage {
+		Message: message,
+		Game: game,
+	}
+}
+
+func NewTournamentMessage(tournament interface{}) *TournamentMessage {
+	message := Message {
+		Type: "tournament",
+	}
+	return &TournamentMessage {
+		Message: message,
+		Tournament: tournament,
+	}
+}
+
+func NewLeaderboardMessage(engine string, ratings interface{}) *LeaderboardMessage {
+	message := Message {
+		Type: "leaderboard",
+	}
+	return &LeaderboardMessage {
+		Message: message,
+		Engine: engine,
+		Ratings: ratings,
+	}
+}
+
+func NewDisconnectedMessage(game int, player string) *DisconnectedMessage {
+	message := Message {
+		Type: "disconnected",
+	}
+	return &DisconnectedMessage {
+		Message: message,
+		Game: game,
+		Player: player,
+	}
+}
+
+func NewReconnectedMessage(game int, player string) *ReconnectedMessage {
+	message := Message {
+		Type: "reconnected",
+	}
+	return &ReconnectedMessage {
+		Message: message,
+		Game: game,
+		Player: player,
+	}
+}
+
+func NewForfeitMessage(game int, player string, policy string) *ForfeitMessage {
+	message := Message {
+		Type: "forfeit",
+	}
+	return &ForfeitMessage {
+		Message: message,
+		Game: game,
+		Player: player,
+		Policy: policy,
+	}
+}
+
+func NewAbortedMessage(game int, reason string) *AbortedMessage {
+	message := Message {
+		Type: "aborted",
+	}
+	return &AbortedMessage {
+		Message: message,
+		Game: game,
+		Reason: reason,
 	}
 }
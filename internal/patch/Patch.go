@@ -0,0 +1,121 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	OP_ADD     = "add"
+	OP_REMOVE  = "remove"
+	OP_REPLACE = "replace"
+)
+
+// a single JSON Patch (RFC 6902) operation
+type Operation struct {
+	Op string          `json:"op"`
+	Path string        `json:"path"`
+	Value interface{}  `json:"value,omitempty"`
+}
+
+// returns the operations that turn the first json document into the second one
+func Diff(from []byte, to []byte) ([]*Operation, error) {
+	a, err := decode(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := decode(to)
+	if err != nil {
+		return nil, err
+	}
+	result := []*Operation{}
+	diff("", a, b, &result)
+	return result, nil
+}
+
+func decode(raw []byte) (interface{}, error) {
+	var result interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber() // keep numbers exactly as the engine sent them
+	err := decoder.Decode(&result)
+	return result, err
+}
+
+func diff(path string, a interface{}, b interface{}, result *[]*Operation) {
+	switch typedA := a.(type) {
+		case map[string]interface{}:
+			typedB, ok := b.(map[string]interface{})
+			if ok {
+				diffObjects(path, typedA, typedB, result)
+				return
+			}
+		case []interface{}:
+			typedB, ok := b.([]interface{})
+			if ok {
+				diffArrays(path, typedA, typedB, result)
+				return
+			}
+	}
+	if !reflect.DeepEqual(a, b) {
+		*result = append(*result, &Operation{Op: OP_REPLACE, Path: path, Value: nullable(b)})
+	}
+}
+
+func diffObjects(path string, a map[string]interface{}, b map[string]interface{}, result *[]*Operation) {
+	for _,key := range sortedKeys(a) {
+		if _, found := b[key]; !found {
+			*result = append(*result, &Operation{Op: OP_REMOVE, Path: path + "/" + escape(key)})
+		}
+	}
+	for _,key := range sortedKeys(b) {
+		valueA, found := a[key]
+		if !found {
+			*result = append(*result, &Operation{Op: OP_ADD, Path: path + "/" + escape(key), Value: nullable(b[key])})
+			continue
+		}
+		diff(path + "/" + escape(key), valueA, b[key], result)
+	}
+}
+
+func diffArrays(path string, a []interface{}, b []interface{}, result *[]*Operation) {
+	common := len(a)
+	if len(b) < common {
+		common = len(b)
+	}
+	for i := 0; i < common; i++ {
+		diff(path + "/" + strconv.Itoa(i), a[i], b[i], result)
+	}
+	// remove from the back, so the indices of the remaining elements stay valid
+	for i := len(a)-1; i >= common; i-- {
+		*result = append(*result, &Operation{Op: OP_REMOVE, Path: path + "/" + strconv.Itoa(i)})
+	}
+	for i := common; i < len(b); i++ {
+		*result = append(*result, &Operation{Op: OP_ADD, Path: path + "/" + strconv.Itoa(i), Value: nullable(b[i])})
+	}
+}
+
+func sortedKeys(value map[string]interface{}) []string {
+	result := make([]string, 0, len(value))
+	for key := range value {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// see RFC 6901
+func escape(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+// a null value has to be written explicitly, omitempty would drop it
+func nullable(value interface{}) interface{} {
+	if value == nil {
+		return json.RawMessage("null")
+	}
+	return value
+}
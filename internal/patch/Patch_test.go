@@ -0,0 +1,157 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to string
+		expected string
+	}{
+		{"equal", `{"a":[1,{"b":null}]}`, `{"a":[1,{"b":null}]}`, `[]`},
+		{"replace a number", `{"a":1}`, `{"a":2}`, `[{"op":"replace","path":"/a","value":2}]`},
+		{"numbers stay exact", `{"a":1.0}`, `{"a":1.00}`, `[{"op":"replace","path":"/a","value":1.00}]`},
+		{"add and remove keys", `{"a":1,"b":2}`, `{"b":2,"c":3}`, `[{"op":"remove","path":"/a"},{"op":"add","path":"/c","value":3}]`},
+		{"nested object", `{"a":{"b":{"c":1}}}`, `{"a":{"b":{"c":2}}}`, `[{"op":"replace","path":"/a/b/c","value":2}]`},
+		{"array grows", `[1,2]`, `[1,2,3,4]`, `[{"op":"add","path":"/2","value":3},{"op":"add","path":"/3","value":4}]`},
+		{"array shrinks from the back", `[1,2,3,4]`, `[1,2]`, `[{"op":"remove","path":"/3"},{"op":"remove","path":"/2"}]`},
+		{"array shrinks and changes", `[1,2,3]`, `[5]`, `[{"op":"replace","path":"/0","value":5},{"op":"remove","path":"/2"},{"op":"remove","path":"/1"}]`},
+		{"array becomes empty", `{"a":[1,2]}`, `{"a":[]}`, `[{"op":"remove","path":"/a/1"},{"op":"remove","path":"/a/0"}]`},
+		{"object becomes array", `{"a":{"b":1}}`, `{"a":[1]}`, `[{"op":"replace","path":"/a","value":[1]}]`},
+		{"array becomes object", `{"a":[1]}`, `{"a":{"b":1}}`, `[{"op":"replace","path":"/a","value":{"b":1}}]`},
+		{"number becomes string", `{"a":1}`, `{"a":"1"}`, `[{"op":"replace","path":"/a","value":"1"}]`},
+		{"whole document", `[1]`, `{"a":1}`, `[{"op":"replace","path":"","value":{"a":1}}]`},
+		{"value becomes null", `{"a":1}`, `{"a":null}`, `[{"op":"replace","path":"/a","value":null}]`},
+		{"null becomes value", `{"a":null}`, `{"a":false}`, `[{"op":"replace","path":"/a","value":false}]`},
+		{"add null", `{}`, `{"a":null}`, `[{"op":"add","path":"/a","value":null}]`},
+		{"append null", `[]`, `[null]`, `[{"op":"add","path":"/0","value":null}]`},
+		{"remove null", `{"a":null}`, `{}`, `[{"op":"remove","path":"/a"}]`},
+		{"escape slash", `{"a/b":1}`, `{"a/b":2}`, `[{"op":"replace","path":"/a~1b","value":2}]`},
+		{"escape tilde", `{"a~b":1}`, `{"a~b":2}`, `[{"op":"replace","path":"/a~0b","value":2}]`},
+		{"escape both", `{}`, `{"~/":{"/~":1}}`, `[{"op":"add","path":"/~0~1","value":{"/~":1}}]`},
+		{"escape nested", `{"~1":{"/":1}}`, `{"~1":{"/":2}}`, `[{"op":"replace","path":"/~01/~1","value":2}]`},
+		{"empty key", `{"":1}`, `{"":2}`, `[{"op":"replace","path":"/","value":2}]`},
+	}
+	for _,test := range tests {
+		operations, err := Diff([]byte(test.from), []byte(test.to))
+		if err != nil {
+			t.Errorf("%s: unexpected error [%s]", test.name, err)
+			continue
+		}
+		actual, _ := json.Marshal(operations)
+		if string(actual) != test.expected {
+			t.Errorf("%s: diff of [%s] and [%s] gave [%s], expected [%s]", test.name, test.from, test.to, actual, test.expected)
+		}
+
+		// applying the patch has to give the second document
+		var document interface{}
+		json.Unmarshal([]byte(test.from), &document)
+		var decoded []map[string]interface{}
+		json.Unmarshal(actual, &decoded)
+		for _,operation := range decoded {
+			document, err = apply(document, operation)
+			if err != nil {
+				t.Errorf("%s: could not apply [%v] : [%s]", test.name, operation, err)
+				break
+			}
+		}
+		var expected interface{}
+		json.Unmarshal([]byte(test.to), &expected)
+		if !reflect.DeepEqual(document, expected) {
+			t.Errorf("%s: applying the diff gave [%v], expected [%v]", test.name, document, expected)
+		}
+	}
+}
+
+func TestDiffInvalidJson(t *testing.T) {
+	_, err := Diff([]byte(`{"a":`), []byte(`{}`))
+	if err == nil {
+		t.Error("expected an error for invalid json")
+	}
+}
+
+
+
+// a minimal RFC 6902 implementation of the operations that Diff creates
+
+func apply(document interface{}, operation map[string]interface{}) (interface{}, error) {
+	path := operation["path"].(string)
+	if path == "" {
+		if operation["op"] != OP_REPLACE {
+			return nil, errors.New(fmt.Sprintf("Unexpected operation on the whole document [%s]", operation["op"]))
+		}
+		return operation["value"], nil
+	}
+	tokens := strings.Split(path, "/")[1:]
+	for i := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tokens[i], "~1", "/"), "~0", "~")
+	}
+	return applyAt(document, tokens, operation)
+}
+
+func applyAt(document interface{}, tokens []string, operation map[string]interface{}) (interface{}, error) {
+	token := tokens[0]
+	last := len(tokens) == 1
+	switch typed := document.(type) {
+		case map[string]interface{}:
+			if !last {
+				child, found := typed[token]
+				if !found {
+					return nil, errors.New(fmt.Sprintf("Key [%s] not found", token))
+				}
+				updated, err := applyAt(child, tokens[1:], operation)
+				typed[token] = updated
+				return typed, err
+			}
+			_, found := typed[token]
+			switch operation["op"] {
+				case OP_ADD:
+					typed[token] = operation["value"]
+				case OP_REMOVE, OP_REPLACE:
+					if !found {
+						return nil, errors.New(fmt.Sprintf("Key [%s] not found", token))
+					}
+					if operation["op"] == OP_REMOVE {
+						delete(typed, token)
+					} else {
+						typed[token] = operation["value"]
+					}
+			}
+			return typed, nil
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index > len(typed) {
+				return nil, errors.New(fmt.Sprintf("Invalid index [%s]", token))
+			}
+			if !last {
+				updated, err := applyAt(typed[index], tokens[1:], operation)
+				typed[index] = updated
+				return typed, err
+			}
+			switch operation["op"] {
+				case OP_ADD:
+					typed = append(typed, nil)
+					copy(typed[index+1:], typed[index:])
+					typed[index] = operation["value"]
+					return typed, nil
+			}
+			if index == len(typed) {
+				return nil, errors.New(fmt.Sprintf("Index [%d] out of bounds", index))
+			}
+			if operation["op"] == OP_REMOVE {
+				return append(typed[:index], typed[index+1:]...), nil
+			}
+			typed[index] = operation["value"]
+			return typed, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Cannot apply [%s] to a [%T]", operation["path"], document))
+}
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	BUCKET_GAMES    = []byte("games")
+	BUCKET_TURNS    = []byte("turns") // contains a nested bucket per game
+	BUCKET_COUNTERS = []byte("counters")
+	BUCKET_TOKENS   = []byte("tokens")
+	BUCKET_RATINGS  = []byte("ratings")
+)
+
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _,name := range [][]byte{BUCKET_GAMES, BUCKET_TURNS, BUCKET_COUNTERS, BUCKET_TOKENS, BUCKET_RATINGS} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Infof("Opened storage at [%s]", path)
+	return &BoltStorage {
+		db: db,
+	}, nil
+}
+
+func (this *BoltStorage) SaveGame(game *GameRecord) error {
+	value, err := json.Marshal(game)
+	if err != nil {
+		return err
+	}
+	return this.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(BUCKET_GAMES).Put(itob(game.Id), value)
+	})
+}
+
+func (this *BoltStorage) SaveTurn(gameId int, turn *TurnRecord) error {
+	value, err := json.Marshal(turn)
+	if err != nil {
+		return err
+	}
+	return this.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(BUCKET_TURNS).CreateBucketIfNotExists(itob(gameId))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(turn.Turn), value)
+	})
+}
+
+func (this *BoltStorage) LoadGames() ([]*GameRecord, error) {
+	result := []*GameRecord{}
+	err := this.db.View(func(tx *bolt.Tx) error {
+		turns := tx.Bucket(BUCKET_TURNS)
+		return tx.Bucket(BUCKET_GAMES).ForEach(func(key []byte, value []byte) error {
+			game := &GameRecord{}
+			err := json.Unmarshal(value, game)
+			if err != nil {
+				return err
+			}
+			game.Turns = []*TurnRecord{}
+			if !game.Archived {
+				game.Turns, err = loadTurns(turns.Bucket(key))
+				if err != nil {
+					return err
+				}
+			}
+			result = append(result, game)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (this *BoltStorage) LoadGame(id int) (*GameRecord, error) {
+	var result *GameRecord
+	err := this.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(BUCKET_GAMES).Get(itob(id))
+		if value == nil {
+			return nil
+		}
+		game := &GameRecord{}
+		err := json.Unmarshal(value, game)
+		if err != nil {
+			return err
+		}
+		game.Turns, err = loadTurns(tx.Bucket(BUCKET_TURNS).Bucket(itob(id)))
+		if err != nil {
+			return err
+		}
+		result = game
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// the bucket can be nil for games without turns
+func loadTurns(bucket *bolt.Bucket) ([]*TurnRecord, error) {
+	result := []*TurnRecord{}
+	if bucket == nil {
+		return result, nil
+	}
+	err := bucket.ForEach(func(_ []byte, value []byte) error {
+		turn := &TurnRecord{}
+		err := json.Unmarshal(value, turn)
+		if err != nil {
+			return err
+		}
+		result = append(result, turn)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (this *BoltStorage) SaveCounter(name string, value int) error {
+	return this.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(BUCKET_COUNTERS).Put([]byte(name), itob(value))
+	})
+}
+
+func (this *BoltStorage) LoadCounter(name string) (int, error) {
+	result := 0
+	err := this.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(BUCKET_COUNTERS).Get([]byte(name))
+		if value != nil {
+			result = btoi(value)
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (this *BoltStorage) SaveToken(token *TokenRecord) error {
+	value, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return this.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(BUCKET_TOKENS).Put([]byte(token.Token), value)
+	})
+}
+
+func (this *BoltStorage) LoadTokens() ([]*TokenRecord, error) {
+	result := []*TokenRecord{}
+	err := this.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(BUCKET_TOKENS).ForEach(func(_ []byte, value []byte) error {
+			token := &TokenRecord{}
+			err := json.Unmarshal(value, token)
+			if err != nil {
+				return err
+			}
+			result = append(result, token)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (this *BoltStorage) SaveRating(rating *RatingRecord) error {
+	value, err := json.Marshal(rating)
+	if err != nil {
+		return err
+	}
+	key := []byte(rating.Game + "\x00" + rating.Name)
+	return this.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(BUCKET_RATINGS).Put(key, value)
+	})
+}
+
+func (this *BoltStorage) LoadRatings() ([]*RatingRecord, error) {
+	result := []*RatingRecord{}
+	err := this.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(BUCKET_RATINGS).ForEach(func(_ []byte, value []byte) error {
+			rating := &RatingRecord{}
+			err := json.Unmarshal(value, rating)
+			if err != nil {
+				return err
+			}
+			result = append(result, rating)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (this *BoltStorage) Close() error {
+	return this.db.Close()
+}
+
+
+
+// util
+
+// big endian, so keys are sorted by their numeric value
+func itob(value int) []byte {
+	result := make([]byte, 8)
+	binary.BigEndian.PutUint64(result, uint64(value))
+	return result
+}
+
+func btoi(value []byte) int {
+	return int(binary.BigEndian.Uint64(value))
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"time"
+	msg "github.com/Project-Wartemis/pw-backend/internal/message"
+)
+
+type Storage interface {
+	SaveGame(game *GameRecord) error
+	SaveTurn(gameId int, turn *TurnRecord) error
+	LoadGames() ([]*GameRecord, error) // turns included, except for archived games
+	LoadGame(id int) (*GameRecord, error) // turns included, nil when not found
+	SaveCounter(name string, value int) error
+	LoadCounter(name string) (int, error)
+	SaveToken(token *TokenRecord) error
+	LoadTokens() ([]*TokenRecord, error)
+	SaveRating(rating *RatingRecord) error
+	LoadRatings() ([]*RatingRecord, error)
+	Close() error
+}
+
+type ClientRecord struct {
+	Id int       `json:"id"`
+	Type string  `json:"type"`
+	Name string  `json:"name"`
+	Game string  `json:"game"`
+}
+
+type PlayerRecord struct {
+	Id int              `json:"id"`
+	Client *ClientRecord `json:"client"`
+	Eliminated bool     `json:"eliminated"`
+}
+
+type GameRecord struct {
+	Id int                  `json:"id"`
+	Name string             `json:"name"`
+	Engine *ClientRecord    `json:"engine"`
+	Creator *ClientRecord   `json:"creator"` // nil when created through the api
+	Players []*PlayerRecord `json:"players"`
+	Started bool            `json:"started"`
+	Stopped bool            `json:"stopped"`
+	MoveTimeout int         `json:"moveTimeout"`
+	Autoplay bool           `json:"autoplay"`
+	ReconnectTimeout int    `json:"reconnectTimeout"`
+	ForfeitPolicy string    `json:"forfeitPolicy"`
+	Replay bool             `json:"replay"` // imported from a replay file
+	Result *msg.GameResult  `json:"result"`
+	Aborted bool            `json:"aborted"`
+	AbortReason string      `json:"abortReason"`
+	StoppedAt time.Time     `json:"stoppedAt"`
+	Archived bool           `json:"archived"` // no longer kept in memory, only loaded when requested
+	Turns []*TurnRecord     `json:"-"` // stored separately, one entry per turn
+}
+
+type TurnRecord struct {
+	Turn int                          `json:"turn"`
+	Message *msg.StateMessage         `json:"message"` // nil for imported replays
+	Converted *msg.StateMessageOut    `json:"converted"`
+}
+
+type TokenRecord struct {
+	Token string      `json:"token"`
+	ClientType string `json:"clientType"`
+	Name string       `json:"name"`
+}
+
+type RatingRecord struct {
+	Game string      `json:"game"`
+	Name string      `json:"name"`
+	Rating float64   `json:"rating"`
+	Games int        `json:"games"`
+	Wins int         `json:"wins"`
+}
@@ -24,3 +24,18 @@ func (this *SafeCounter) GetNext() int {
 	this.v++
 	return this.v
 }
+
+func (this *SafeCounter) Get() int {
+	this.Lock()
+	defer this.Unlock()
+	return this.v
+}
+
+// makes sure the next value is greater than the given one
+func (this *SafeCounter) SetMinimum(v int) {
+	this.Lock()
+	defer this.Unlock()
+	if v > this.v {
+		this.v = v
+	}
+}
@@ -0,0 +1,34 @@
+package validation
+
+import (
+	"encoding/json"
+	"github.com/qri-io/jsonschema"
+)
+
+type Schema struct {
+	root *jsonschema.RootSchema
+}
+
+func NewSchema(raw []byte) (*Schema, error) {
+	root := &jsonschema.RootSchema{}
+	err := json.Unmarshal(raw, root)
+	if err != nil {
+		return nil, err
+	}
+	return &Schema {
+		root: root,
+	}, nil
+}
+
+// returns the list of validation errors, or an error if the input is not valid json
+func (this *Schema) Validate(raw []byte) ([]string, error) {
+	mistakes, err := this.root.ValidateBytes(raw)
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _,mistake := range mistakes {
+		result = append(result, mistake.Error())
+	}
+	return result, nil
+}
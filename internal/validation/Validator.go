@@ -1,56 +1,56 @@
 package validation
 
 import (
-	// "os"
+	"errors"
+	"fmt"
 	"io/ioutil"
-	"encoding/json"
-	"github.com/qri-io/jsonschema"
-	"github.com/sirupsen/logrus"
+	"path/filepath"
+	"strings"
+	log "github.com/sirupsen/logrus"
 )
 
-const (
-	BOT_MOVE = 0
-	BOT_REGISTER = 1
-	NEW_GAME_REQUEST = 2
-	NEW_GAME_RESPONSE = 3
+var (
+	SCHEMA_DIRECTORY = "internal/validation/resources/messages" // one <message type>.json per message type
 )
 
 type Validator struct {
-	schemas map[int]*jsonschema.RootSchema
+	schemas map[string]*Schema
 }
 
 func NewValidator() *Validator {
-	v := Validator{}
-	v.schemas = map[int]*jsonschema.RootSchema{
-		BOT_MOVE: loadSchema("pkg/validation/resources/bots/botMove.json"),
-		BOT_REGISTER: loadSchema("pkg/validation/resources/bots/botRegister.json"),
-		NEW_GAME_REQUEST: loadSchema("pkg/validation/resources/RESTapi/NewGameRequest.json"),
-		NEW_GAME_RESPONSE: loadSchema("pkg/validation/resources/RESTapi/NewGameResponse.json"),
+	files, err := filepath.Glob(filepath.Join(SCHEMA_DIRECTORY, "*.json"))
+	if err != nil || len(files) == 0 {
+		log.Panicf("No schemas found in [%s]", SCHEMA_DIRECTORY)
 	}
-	return &v
-}
-
-func loadSchema(schemaPath string) *jsonschema.RootSchema{
-	schemaData, err := ioutil.ReadFile(schemaPath)
-	if err != nil {
-		logrus.Fatalf("Failed to read schema file: %s", err)
-		panic(err)
+	schemas := map[string]*Schema{}
+	for _,file := range files {
+		messageType := strings.TrimSuffix(filepath.Base(file), ".json")
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Panicf("Could not read schema [%s] : [%s]", file, err)
+		}
+		schema, err := NewSchema(raw)
+		if err != nil {
+			log.Panicf("Invalid schema for message type [%s] : [%s]", messageType, err)
+		}
+		schemas[messageType] = schema
 	}
-	rs := &jsonschema.RootSchema{}
-	if err := json.Unmarshal(schemaData, rs); err != nil {
-		logrus.Fatalf("Unable to read schema: %s", err)
-		panic(err)
+	log.Infof("Loaded [%d] schemas from [%s]", len(schemas), SCHEMA_DIRECTORY)
+	return &Validator {
+		schemas: schemas,
 	}
-	return rs
 }
 
-func (v *Validator) ValidateBytes(bytes []byte, schemaEnum int) bool {
-	schema := v.schemas[schemaEnum]
-	mistakes, err := schema.ValidateBytes(bytes)
+// returns the list of validation errors for a message of the given type
+// message types without a schema are not validated
+func (this *Validator) Validate(messageType string, raw []byte) ([]string, error) {
+	schema, found := this.schemas[messageType]
+	if !found {
+		return []string{}, nil
+	}
+	mistakes, err := schema.Validate(raw)
 	if err != nil {
-		logrus.Fatalf("Validation error: %s", err)
-		panic(err)
+		return nil, errors.New(fmt.Sprintf("Could not validate message of type [%s] : [%s]", messageType, err))
 	}
-	logrus.Info(mistakes)
-	return len(mistakes) == 0
+	return mistakes, nil
 }
@@ -1,7 +1,21 @@
 import requests
 
-d = {"bots": ["QBot"], "gameEngineName": "demoEngine"}
+BASE = 'http://localhost:8080/api'
 
-resp = requests.post('http://localhost:80/game/new', json=d)
+# look up the ids of the engine and bot in the lobby
+clients = requests.get(BASE + '/clients').json()
+engine = next(c['id'] for c in clients if c['type'] == 'engine')
+bot = next(c['id'] for c in clients if c['type'] == 'bot' and c['name'] == 'QBot')
+
+resp = requests.post(BASE + '/games', json={'name': 'demo', 'engine': engine})
+print(resp)
+print(resp.text)
+game = resp.json()['id']
+
+resp = requests.post(BASE + '/games/%d/players' % game, json={'bot': bot})
+print(resp)
+print(resp.text)
+
+resp = requests.post(BASE + '/games/%d/start' % game)
 print(resp)
-print(resp.text)
\ No newline at end of file
+print(resp.text)